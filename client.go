@@ -8,6 +8,8 @@ import (
 	"io/ioutil"
 	. "net/http"
 	"net/http/cookiejar"
+	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/thedevsaddam/gojsonq"
@@ -16,6 +18,8 @@ import (
 type HttpClient struct {
 	Client
 	headers Header
+	// endpointName names a url for metrics, requests are not measured when nil
+	endpointName func(url string) string
 }
 
 type HttpResponse struct {
@@ -29,10 +33,10 @@ func NewHttpClient() (*HttpClient, error) {
 	}
 
 	return &HttpClient{
-		Client{
+		Client: Client{
 			Jar: jar,
 		},
-		Header{
+		headers: Header{
 			"User-Agent": []string{"BL3 Auto Vip"},
 		},
 	}, nil
@@ -65,6 +69,9 @@ func (response *HttpResponse) BodyAsJson() (*gojsonq.JSONQ, error) {
 }
 
 func getResponse(res *Response, err error) (*HttpResponse, error) {
+	if err != nil {
+		return nil, err
+	}
 	return &HttpResponse{
 		*res,
 	}, err
@@ -80,7 +87,13 @@ func (client *HttpClient) Do(req *Request) (*HttpResponse, error) {
 			req.Header.Set(k, x)
 		}
 	}
-	return getResponse(client.Client.Do(req))
+	if client.endpointName == nil {
+		return getResponse(client.Client.Do(req))
+	}
+	start := time.Now()
+	res, err := client.Client.Do(req)
+	observeHttp(client.endpointName(req.URL.String()), start, res, err)
+	return getResponse(res, err)
 }
 
 func (client *HttpClient) Get(url string) (*HttpResponse, error) {
@@ -119,6 +132,20 @@ func (client *HttpClient) PostJson(url string, data interface{}) (*HttpResponse,
 type Bl3Client struct {
 	HttpClient
 	Config Bl3Config
+	// NegativeCache skips codes that did not work recently, nil checks every code
+	NegativeCache *NegativeCache
+	// ShiftCodeIndex reuses the platforms of SHIFT codes checked before, nil checks every code
+	ShiftCodeIndex *ShiftCodeIndex
+}
+
+// ErrInvalidConfig is returned when the config was read but a setting in it can not be used,
+// like a regex that does not compile
+type ErrInvalidConfig struct {
+	Reason string
+}
+
+func (err *ErrInvalidConfig) Error() string {
+	return err.Reason
 }
 
 func NewBl3Client() (*Bl3Client, error) {
@@ -132,21 +159,49 @@ func NewBl3Client() (*Bl3Client, error) {
 		return nil, errors.New("Failed to get config")
 	}
 
-	configJson, err := res.BodyAsJson()
-	if err != nil {
-		return nil, errors.New("Failed to get config")
-	}
 	config := Bl3Config{}
-	configJson.Out(&config)
+	if err := res.DecodeJson(&config); err != nil {
+		return nil, err
+	}
+	if err := config.Vip.CompileActivityRules(); err != nil {
+		return nil, &ErrInvalidConfig{Reason: err.Error()}
+	}
+	if err := config.Vip.CompileActivityReset(); err != nil {
+		return nil, &ErrInvalidConfig{Reason: err.Error()}
+	}
+	if err := config.Vip.CompileCodeListRules(); err != nil {
+		return nil, &ErrInvalidConfig{Reason: err.Error()}
+	}
 
 	for header, value := range config.RequestHeaders {
 		client.SetDefaultHeader(header, value)
 	}
 
-	return &Bl3Client {
+	bl3Client := &Bl3Client {
 		HttpClient: *client,
 		Config: config,
-	}, nil
+	}
+	bl3Client.endpointName = bl3Client.Config.endpointName
+	return bl3Client, nil
+}
+
+// endpointName groups urls by the config field they come from
+func (config *Bl3Config) endpointName(url string) string {
+	switch {
+	case config.LoginUrl != "" && strings.HasPrefix(url, config.LoginUrl):
+		return "loginUrl"
+	case config.Shift.CodeInfoUrl != "" && strings.HasPrefix(url, config.Shift.CodeInfoUrl):
+		return "codeInfoUrl"
+	case config.Shift.UserInfoUrl != "" && strings.HasPrefix(url, config.Shift.UserInfoUrl):
+		return "userInfoUrl"
+	case strings.Contains(url, "crowdtwist.com/request"):
+		return "crowdtwist request"
+	case strings.Contains(url, "crowdtwist.com/code-redemption-campaign"):
+		return "crowdtwist code redemption"
+	case strings.Contains(url, "crowdtwist.com/widgets"):
+		return "crowdtwist widget"
+	}
+	return "other"
 }
 
 func (client *Bl3Client) Login(username string, password string) error {
@@ -157,15 +212,18 @@ func (client *Bl3Client) Login(username string, password string) error {
 
 	loginRes, err := client.PostJson(client.Config.LoginUrl, data)
 	if err != nil {
+		MetricLoginFailures.Inc()
 		return errors.New("Failed to submit login credentials")
 	}
 	defer loginRes.Body.Close()
 
 	if loginRes.StatusCode != 200 {
+		MetricLoginFailures.Inc()
 		return errors.New("Failed to login")
 	}
 
 	if loginRes.Header.Get(client.Config.LoginRedirectHeader) == "" {
+		MetricLoginFailures.Inc()
 		return errors.New("Failed to start session")
 	}
 
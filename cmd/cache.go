@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	bl3 "github.com/matt1484/bl3_auto_vip"
+)
+
+func cacheUsage() {
+	fmt.Println("Usage: bl3-auto-vip cache <export|import|reconcile> [options]")
+}
+
+func doCache(args []string) error {
+	if len(args) < 1 {
+		cacheUsage()
+		return errors.New("missing cache command")
+	}
+
+	switch args[0] {
+	case "export":
+		return doCacheExport(args[1:])
+	case "import":
+		return doCacheImport(args[1:])
+	case "reconcile":
+		return doCacheReconcile(args[1:])
+	}
+	cacheUsage()
+	return errors.New("unknown cache command '" + args[0] + "'")
+}
+
+func doCacheExport(args []string) error {
+	flags := flag.NewFlagSet("cache export", flag.ContinueOnError)
+	account := flags.String("account", "", "Only export this account (email)")
+	output := flags.String("output", "", "File to export to (defaults to stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	accountHash := ""
+	if *account != "" {
+		accountHash = hashUsername(*account)
+	}
+	records, err := store.Records(accountHash)
+	if err != nil {
+		return err
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	return writeRecords(out, "json", records)
+}
+
+func doCacheImport(args []string) error {
+	flags := flag.NewFlagSet("cache import", flag.ContinueOnError)
+	input := flags.String("input", "", "File to import from (defaults to stdin)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	in := io.Reader(os.Stdin)
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	records := make([]bl3.RedemptionRecord, 0)
+	if err := json.Unmarshal(data, &records); err != nil {
+		return errors.New("invalid cache export: " + err.Error())
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	existing, err := store.Records("")
+	if err != nil {
+		return err
+	}
+	seen := bl3.StringSet{}
+	for _, record := range existing {
+		seen.Add(recordKey(record))
+	}
+
+	imported := 0
+	for _, record := range records {
+		if _, found := seen[recordKey(record)]; found {
+			continue
+		}
+		if err := store.Add(record); err != nil {
+			return err
+		}
+		seen.Add(recordKey(record))
+		imported++
+	}
+	fmt.Printf("Imported %d of %d records (%d already present).\n", imported, len(records), len(records)-imported)
+	return nil
+}
+
+// recordKey identifies a record so the same export can be imported twice safely
+func recordKey(record bl3.RedemptionRecord) string {
+	return record.Account + "|" + record.Kind + "|" + record.Code + "|" + record.Platform + "|" + record.Result + "|" + record.Time.UTC().String()
+}
+
+func doCacheReconcile(args []string) error {
+	flags := flag.NewFlagSet("cache reconcile", flag.ContinueOnError)
+	username, password := "", ""
+	addCredentialFlags(flags, &username, &password)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	promptCredentials(&username, &password)
+	client, err := setupClient(username, password)
+	if err != nil {
+		return err
+	}
+	account := hashUsername(username)
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := migrateLegacyCaches(store, &client.Config, account); err != nil {
+		fmt.Println("Failed to migrate old code caches: " + err.Error())
+	}
+
+	fmt.Print("Getting locally redeemed VIP codes . . . . . ")
+	localCodes, err := client.Config.RedeemedVipCodes(store, account)
+	if err != nil {
+		printError(err)
+		return err
+	}
+	fmt.Println("success!")
+
+	fmt.Print("Getting VIP codes redeemed on the server . . . . . ")
+	serverCodes := client.Config.NewVipCodeMap()
+	serverTimes := bl3.VipCodeTimes{}
+	if _, err := client.AddRedeemedVipCodes(serverCodes, serverTimes, time.Time{}); err != nil {
+		printError(err)
+		return err
+	}
+	fmt.Println("success!")
+
+	missingLocally := serverCodes.Diff(localCodes)
+	missingOnServer := localCodes.Diff(serverCodes)
+
+	for _, codeType := range sortedCodeTypes(missingLocally) {
+		for _, code := range sortedCodes(missingLocally[codeType]) {
+			fmt.Println("Redeemed on the server but missing locally: '" + codeType + "' VIP code '" + code + "'. Adding it.")
+			// not an attempt, so it only goes in the history and not in the metrics
+			err := store.Add(bl3.RedemptionRecord{
+				Account:  account,
+				Code:     code,
+				Kind:     bl3.KindVip,
+				Platform: codeType,
+				Result:   bl3.ResultRedeemed,
+				Message:  "found in server redemption history",
+				Time:     serverTimes.Get(codeType, code),
+				Source:   bl3.SourceServer,
+			})
+			if err != nil {
+				fmt.Println("Failed to save redemption history: " + err.Error())
+			}
+		}
+	}
+	for _, codeType := range sortedCodeTypes(missingOnServer) {
+		for _, code := range sortedCodes(missingOnServer[codeType]) {
+			fmt.Println("Redeemed locally but unknown to the server: '" + codeType + "' VIP code '" + code + "'.")
+		}
+	}
+
+	discrepancies := countCodes(missingLocally) + countCodes(missingOnServer)
+	if discrepancies == 0 {
+		fmt.Println("The local VIP codes match the server.")
+	} else {
+		fmt.Printf("Found %d VIP code discrepancies.\n", discrepancies)
+	}
+
+	// 2K does not expose a list of redeemed SHIFT codes, so there is nothing to compare against
+	fmt.Println("SHIFT codes can not be reconciled, the server does not provide a redemption history.")
+	return nil
+}
+
+func sortedCodeTypes(codeMap bl3.VipCodeMap) []string {
+	codeTypes := make([]string, 0, len(codeMap))
+	for codeType := range codeMap {
+		codeTypes = append(codeTypes, codeType)
+	}
+	sort.Strings(codeTypes)
+	return codeTypes
+}
+
+func sortedCodes(codes bl3.StringSet) []string {
+	sorted := make([]string, 0, len(codes))
+	for code := range codes {
+		sorted = append(sorted, code)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func countCodes(codeMap bl3.VipCodeMap) int {
+	count := 0
+	for _, codes := range codeMap {
+		count += len(codes)
+	}
+	return count
+}
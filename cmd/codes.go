@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	bl3 "github.com/matt1484/bl3_auto_vip"
+)
+
+// readManualCodes gathers the codes given through flags, a file or stdin
+func readManualCodes(singleShiftCode string, vipCodes []string, codesFile, codesText string, codeTypes []string) (runOptions, error) {
+	opts := runOptions{
+		shiftCodes: make([]string, 0),
+		vipCodes:   append([]string{}, vipCodes...),
+	}
+	if singleShiftCode != "" {
+		opts.shiftCodes = append(opts.shiftCodes, singleShiftCode)
+	}
+
+	text := ""
+	if codesFile != "" {
+		data, err := ioutil.ReadFile(codesFile)
+		if err != nil {
+			return opts, err
+		}
+		text += string(data) + "\n"
+	}
+	if codesText == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return opts, err
+		}
+		text += string(data) + "\n"
+	} else if codesText != "" {
+		text += strings.Replace(codesText, ",", "\n", -1) + "\n"
+	}
+
+	shiftCodes, parsedVipCodes := bl3.ParseCodes(text, codeTypes)
+	opts.shiftCodes = append(opts.shiftCodes, shiftCodes...)
+	opts.vipCodes = append(opts.vipCodes, parsedVipCodes...)
+	// running everything instead of the batch would be a surprise
+	if (codesFile != "" || codesText != "") && len(opts.shiftCodes) == 0 && len(opts.vipCodes) == 0 {
+		return opts, errors.New("No SHIFT or VIP codes found in the given codes")
+	}
+	return opts, nil
+}
+
+// printCodeResults prints what happened to every code the user gave
+func printCodeResults(report *runReport) {
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "CODE\tKIND\tPLATFORM/TYPE\tRESULT\tMESSAGE")
+	for _, stage := range report.Stages {
+		for _, record := range stage.Redemptions {
+			fmt.Fprintln(table, strings.Join([]string{record.Code, record.Kind, record.Platform, record.Result, record.Message}, "\t"))
+		}
+		for _, skipped := range stage.Skipped {
+			fmt.Fprintln(table, strings.Join([]string{skipped.Code, skipped.Kind, "", "skipped", skipped.Reason}, "\t"))
+		}
+	}
+	table.Flush()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	bl3 "github.com/matt1484/bl3_auto_vip"
+)
+
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+type checkResult struct {
+	Name   string
+	Status string
+	Detail string
+	Hint   string
+}
+
+// doctor runs each check in turn, later checks are skipped when what they need failed
+type doctor struct {
+	results []checkResult
+}
+
+func (d *doctor) add(name, status, detail, hint string) {
+	fmt.Println("Checking " + name + " . . . . . " + status)
+	d.results = append(d.results, checkResult{Name: name, Status: status, Detail: detail, Hint: hint})
+}
+
+func (d *doctor) check(name string, err error, detail, hint string) bool {
+	if err != nil {
+		d.add(name, checkFail, err.Error(), hint)
+		return false
+	}
+	d.add(name, checkPass, detail, "")
+	return true
+}
+
+func (d *doctor) skip(name, reason string) {
+	d.add(name, checkSkip, reason, "")
+}
+
+func doDoctor(args []string) error {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	username, password := "", ""
+	addCredentialFlags(flags, &username, &password)
+	code := flags.String("code", "", "SHIFT code to check the code info endpoint with (default the first code in the SHIFT code list)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	promptCredentials(&username, &password)
+
+	d := doctor{}
+	client, err := bl3.NewBl3Client()
+	configHint := "Check your internet connection and that GitHub is reachable."
+	if _, invalid := err.(*bl3.ErrInvalidConfig); invalid {
+		configHint = "The setting named in the error is invalid in the config, update to the latest version or report it."
+	} else if bl3.IsUnexpectedResponseShape(err) {
+		configHint = "The config changed in a way this version does not understand, update to the latest version."
+	}
+	if !d.check("config", err, "loaded", configHint) {
+		d.skip("version", "no config")
+		d.skip("login", "no config")
+		d.skip("SHIFT platforms", "no config")
+		d.skip("SHIFT code list", "no config")
+		d.skip("SHIFT code info", "no config")
+		d.skip("crowdtwist widget config", "no config")
+		d.skip("VIP code list selector", "no config")
+		return d.report()
+	}
+
+	if client.Config.Version != version {
+		d.add("version", checkWarn, "running "+version+", latest is "+client.Config.Version,
+			"Download the latest version at https://github.com/matt1484/bl3_auto_vip/releases/latest")
+	} else {
+		d.add("version", checkPass, version, "")
+	}
+
+	loggedIn := d.check("login", client.Login(username, password), "logged in as '"+username+"'",
+		"Check your email and password by logging in at https://borderlands.com/en-US/vip/")
+
+	if loggedIn {
+		platforms, err := client.GetShiftPlatforms()
+		if err == nil && len(platforms) == 0 {
+			d.add("SHIFT platforms", checkWarn, "no platforms linked",
+				"Link a platform to your SHIFT account at https://shift.gearboxsoftware.com")
+		} else {
+			d.check("SHIFT platforms", err, strings.Join(sortedCodes(platforms), ", "),
+				"Check userInfoUrl in the config.")
+		}
+	} else {
+		d.skip("SHIFT platforms", "not logged in")
+	}
+
+	feed, feedErrors, err := client.GetShiftCodeFeed()
+	switch {
+	case err == nil && len(feedErrors) > 0:
+		failed := make([]string, 0)
+		for _, game := range client.Config.Shift.GetGames() {
+			if gameErr, found := feedErrors[game.Codename]; found {
+				failed = append(failed, game.Codename+": "+gameErr.Error())
+			}
+		}
+		d.add("SHIFT code list", checkWarn, strconv.Itoa(len(feed))+" codes, failed for "+strings.Join(failed, "; "),
+			"Check the feedTag of those games in the config.")
+	case err == nil && len(feed) == 0:
+		d.add("SHIFT code list", checkWarn, "no codes", "Check codeListUrl and codeListTagUrl in the config.")
+	default:
+		d.check("SHIFT code list", err, strconv.Itoa(len(feed))+" codes",
+			"Check that shift.orcicorn.com is up and codeListUrl and codeListTagUrl in the config.")
+	}
+
+	if *code == "" && len(feed) > 0 {
+		*code = feed[0].Code
+	}
+	switch {
+	case !loggedIn:
+		d.skip("SHIFT code info", "not logged in")
+	case *code == "":
+		d.skip("SHIFT code info", "no code to check, pass --code")
+	default:
+		gamePlatforms, err := client.GetCodeGamePlatforms(*code)
+		_, rejected := err.(*bl3.ErrCodeRejected)
+		switch {
+		case rejected || err == bl3.ErrNoCodePlatforms:
+			// the code not working for us is an answer too, the endpoint still does what we expect
+			d.add("SHIFT code info", checkPass, *code+": "+err.Error(), "")
+		case bl3.IsUnexpectedResponseShape(err):
+			d.check("SHIFT code info", err, "",
+				"The code info response changed, check codeInfoUrl in the config or update.")
+		default:
+			d.check("SHIFT code info", err, *code+": "+strconv.Itoa(len(gamePlatforms))+" games",
+				"Check that api.2k.com is up and codeInfoUrl in the config.")
+		}
+	}
+
+	if loggedIn {
+		d.check("crowdtwist widget config", client.CheckVipWidgetConf(), "found",
+			"The crowdtwist widget page changed, VIP activities and code types can not be read until updated.")
+	} else {
+		d.skip("crowdtwist widget config", "not logged in")
+	}
+
+	_, tables, err := client.ScrapeVipCodeList()
+	rows := 0
+	details := make([]string, 0)
+	for _, table := range tables {
+		rows += table.Rows
+		details = append(details, table.String())
+	}
+	if err == nil && rows == 0 {
+		d.add("VIP code list selector", checkFail, "no rows matched",
+			"Check codeListTableSelector, codeListColumns and codeListRowSelector in the config against the page at "+client.Config.Vip.CodeListUrl)
+	} else {
+		d.check("VIP code list selector", err, strings.Join(details, "; "),
+			"Check that "+client.Config.Vip.CodeListUrl+" is reachable.")
+	}
+
+	return d.report()
+}
+
+// report prints the results and fails when any check did
+func (d *doctor) report() error {
+	fmt.Println("")
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "CHECK\tRESULT\tDETAILS")
+	failed := 0
+	for _, result := range d.results {
+		if result.Status == checkFail {
+			failed++
+		}
+		fmt.Fprintln(table, strings.Join([]string{result.Name, strings.ToUpper(result.Status), result.Detail}, "\t"))
+	}
+	table.Flush()
+
+	hints := make([]string, 0)
+	for _, result := range d.results {
+		if result.Hint != "" {
+			hints = append(hints, "  "+result.Name+": "+result.Hint)
+		}
+	}
+	if len(hints) > 0 {
+		fmt.Println("")
+		fmt.Println("Hints:")
+		for _, hint := range hints {
+			fmt.Println(hint)
+		}
+	}
+
+	if failed > 0 {
+		return errors.New(strconv.Itoa(failed) + " checks failed")
+	}
+	return nil
+}
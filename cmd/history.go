@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	bl3 "github.com/matt1484/bl3_auto_vip"
+)
+
+const dateFormat = "2006-01-02"
+
+func historyUsage() {
+	fmt.Println("Usage: bl3-auto-vip history <list|export> [options]")
+}
+
+func doHistory(args []string) error {
+	if len(args) < 1 {
+		historyUsage()
+		return errors.New("missing history command")
+	}
+
+	flags := flag.NewFlagSet("history "+args[0], flag.ContinueOnError)
+	filter := addRedemptionFilterFlags(flags)
+	format, output := new(string), new(string)
+	if args[0] == "export" {
+		flags.StringVar(format, "format", "csv", "Export format (csv or json)")
+		flags.StringVar(output, "output", "", "File to export to (defaults to stdout)")
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	recordFilter, err := filter.build()
+	if err != nil {
+		return err
+	}
+	records, err := store.Records(recordFilter.Account)
+	if err != nil {
+		return err
+	}
+	records = bl3.FilterRecords(records, recordFilter)
+
+	switch args[0] {
+	case "list":
+		return writeRecordsTable(os.Stdout, records)
+	case "export":
+		out := io.Writer(os.Stdout)
+		if *output != "" {
+			file, err := os.Create(*output)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			out = file
+		}
+		return writeRecords(out, *format, records)
+	}
+	historyUsage()
+	return errors.New("unknown history command '" + args[0] + "'")
+}
+
+type redemptionFilterFlags struct {
+	account  *string
+	since    *string
+	until    *string
+	kind     *string
+	platform *string
+	codeType *string
+	result   *string
+}
+
+func addRedemptionFilterFlags(flags *flag.FlagSet) *redemptionFilterFlags {
+	return &redemptionFilterFlags{
+		account:  flags.String("account", "", "Only show this account (email)"),
+		since:    flags.String("since", "", "Only show attempts on or after this date ("+dateFormat+")"),
+		until:    flags.String("until", "", "Only show attempts before this date ("+dateFormat+")"),
+		kind:     flags.String("kind", "", "Only show this kind of code ("+bl3.KindShift+" or "+bl3.KindVip+")"),
+		platform: flags.String("platform", "", "Only show SHIFT codes for this platform"),
+		codeType: flags.String("type", "", "Only show VIP codes of this type"),
+		result:   flags.String("result", "", "Only show attempts with this result"),
+	}
+}
+
+func (f *redemptionFilterFlags) build() (bl3.RedemptionFilter, error) {
+	filter := bl3.RedemptionFilter{
+		Kind:     strings.ToLower(*f.kind),
+		Platform: *f.platform,
+		CodeType: *f.codeType,
+		Result:   strings.ToLower(*f.result),
+	}
+	if *f.account != "" {
+		filter.Account = hashUsername(*f.account)
+	}
+	if *f.since != "" {
+		since, err := time.ParseInLocation(dateFormat, *f.since, time.Local)
+		if err != nil {
+			return filter, errors.New("invalid --since date '" + *f.since + "'")
+		}
+		filter.Since = since
+	}
+	if *f.until != "" {
+		until, err := time.ParseInLocation(dateFormat, *f.until, time.Local)
+		if err != nil {
+			return filter, errors.New("invalid --until date '" + *f.until + "'")
+		}
+		filter.Until = until
+	}
+	return filter, nil
+}
+
+func writeRecordsTable(w io.Writer, records []bl3.RedemptionRecord) error {
+	if len(records) == 0 {
+		fmt.Fprintln(w, "No redemptions found.")
+		return nil
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TIME\tACCOUNT\tKIND\tGAME\tCODE\tPLATFORM/TYPE\tRESULT\tSOURCE\tMESSAGE")
+	for _, record := range records {
+		fmt.Fprintln(table, strings.Join([]string{
+			record.Time.Local().Format("2006-01-02 15:04"),
+			shortAccount(record.Account),
+			record.Kind,
+			record.Game,
+			record.Code,
+			record.Platform,
+			record.Result,
+			record.Source,
+			record.Message,
+		}, "\t"))
+	}
+	return table.Flush()
+}
+
+func writeRecords(w io.Writer, format string, records []bl3.RedemptionRecord) error {
+	switch strings.ToLower(format) {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"time", "account", "kind", "game", "code", "platform", "result", "source", "message", "reward"})
+		for _, record := range records {
+			writer.Write([]string{
+				record.Time.Format(time.RFC3339),
+				record.Account,
+				record.Kind,
+				record.Game,
+				record.Code,
+				record.Platform,
+				record.Result,
+				record.Source,
+				record.Message,
+				record.Reward,
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return errors.New("unknown format '" + format + "'")
+}
+
+// the account is stored as a hash of the email, a prefix is enough to tell them apart
+func shortAccount(account string) string {
+	if len(account) > 8 {
+		return account[:8]
+	}
+	return account
+}
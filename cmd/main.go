@@ -4,7 +4,7 @@ import (
 	"bufio"
 	"crypto/md5"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -12,19 +12,33 @@ import (
 	"strings"
 	"time"
 
-	"github.com/shibukawa/configdir"
 	bl3 "github.com/matt1484/bl3_auto_vip"
 )
 
 // gross but effective for now
 const version = "2.1"
 
-var usernameHash string
+// how long discovered VIP code types are trusted before looking again
+const vipDiscoveryTTL = 24 * time.Hour
 
 func printError(err error) {
 	fmt.Println("failed!")
 	fmt.Print("Had error: ")
 	fmt.Println(err)
+	if shapeErr, ok := err.(*bl3.ErrUnexpectedResponseShape); ok {
+		fmt.Println("The API may have changed, the response was: " + responseExcerpt(shapeErr.Payload))
+	} else if bl3.IsSessionExpired(err) {
+		fmt.Println("Log in again, the session is no longer valid.")
+	}
+}
+
+// responseExcerpt keeps error output readable when the response is a whole web page
+func responseExcerpt(payload []byte) string {
+	const max = 500
+	if len(payload) > max {
+		return string(payload[:max]) + " . . ."
+	}
+	return string(payload)
 }
 
 func exit() {
@@ -36,257 +50,318 @@ func exit() {
 	fmt.Println("")
 }
 
-func doVip(client *bl3.Bl3Client) {
-	fmt.Print("Getting available VIP activities (excluding codes) . . . . . ");
-	activities, err := client.GetVipActivities()
-	if err != nil {
-		fmt.Println(err)
-		return
+// listFlag collects a flag that can be given more than once
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// selectStages returns the chosen stages once each, in the order of allStages
+func selectStages(only, skip string) ([]string, error) {
+	selected := bl3.StringSet{}
+	for _, stage := range splitStages(only) {
+		selected.Add(stage)
 	}
-	fmt.Println("success!")
-	foundActivities := false
-	for _, activity := range activities {
-		if !strings.Contains(strings.ToLower(activity.Title), "watch") && !strings.Contains(strings.ToLower(activity.Link), "video") {
-			foundActivities = true
-			fmt.Print("Trying VIP activity '" + activity.Title + "' . . . . . ")
-			if client.RedeemVipActivity(activity) {
-				fmt.Println("success!")
-			} else {
-				fmt.Println("failed!")
+	skipped := bl3.StringSet{}
+	for _, stage := range splitStages(skip) {
+		skipped.Add(stage)
+	}
+	for _, set := range []bl3.StringSet{selected, skipped} {
+		for stage := range set {
+			if !isStage(stage) {
+				return nil, errors.New("unknown stage '" + stage + "'")
 			}
 		}
 	}
-	if !foundActivities {
-		fmt.Println("No new VIP activities at this time. Try again later.")
-	}
-	
 
-	configDirs := configdir.New("bl3-auto-vip", "bl3-auto-vip")
-	configFilename := usernameHash + "-vip-codes.json"
-	redeemedCodesCached := bl3.VipCodeMap{}
-
-	fmt.Print("Getting previously redeemed VIP codes . . . . . ")
-	folder := configDirs.QueryFolderContainsFile(configFilename)
-	if folder != nil {
-		data, err := folder.ReadFile(configFilename)
-		if err == nil {
-			json := bl3.JsonFromBytes(data)
-			if json != nil {
-				json.Out(&redeemedCodesCached)
-			}
+	stages := make([]string, 0)
+	for _, stage := range allStages {
+		if _, found := selected[stage]; only != "" && !found {
+			continue
+		}
+		if _, found := skipped[stage]; !found {
+			stages = append(stages, stage)
 		}
 	}
-	redeemedCodes, err := client.GetRedeemedVipCodeMap()
-	if err != nil {
-		printError(err)
-		return
-	}
-	for codeType, codes := range redeemedCodesCached {
-		for code := range codes {
-			redeemedCodes.Add(codeType, code)
+	return stages, nil
+}
+
+func splitStages(s string) []string {
+	stages := make([]string, 0)
+	for _, stage := range strings.Split(s, ",") {
+		stage = strings.TrimSpace(strings.ToLower(stage))
+		if stage != "" {
+			stages = append(stages, stage)
 		}
 	}
-	fmt.Println("success!")
+	return stages
+}
 
-	fmt.Print("Getting new VIP codes . . . . . ")
-	allCodes, err := client.GetFullVipCodeMap()
-	if err != nil {
-		fmt.Println(err)
-		return
+func isStage(s string) bool {
+	for _, stage := range allStages {
+		if stage == s {
+			return true
+		}
 	}
-	fmt.Println("success!")
+	return false
+}
 
-	newCodes := allCodes.Diff(redeemedCodes)
-	foundCodes := false
-	for codeType, codes := range newCodes {
-		if len(codes) < 1 {
+func selectShiftGames(conf *bl3.ShiftConfig, games string) error {
+	if games == "" {
+		return nil
+	}
+	conf.OnlyGames = bl3.StringSet{}
+	for _, name := range strings.Split(games, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
 			continue
 		}
-		foundCodes = true
-		fmt.Print("Setting up VIP codes of type '" + codeType + "' . . . . . ")
-		_, found := client.Config.Vip.CodeTypeUrlMap[codeType]
+		game, found := conf.FindGame(name)
 		if !found {
-			fmt.Println("invalid! Moving on.")
-			continue
+			return errors.New("unknown game '" + name + "'")
 		}
-		fmt.Println("success!")
+		conf.OnlyGames.Add(game.Codename)
+	}
+	return nil
+}
 
-		for code := range codes {
-			fmt.Print("Trying '" + codeType + "' VIP code '" + code + "' . . . . . ")
-			res, valid := client.RedeemVipCode(codeType, code)
-			if !valid {
-				fmt.Println("failed! Moving on.")
-				continue
-			}
-			redeemedCodes.Add(codeType, code)
-			fmt.Println(res)
-		}
+func hashUsername(username string) string {
+	hasher := md5.New()
+	hasher.Write([]byte(username))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+func addCredentialFlags(flags *flag.FlagSet, username, password *string) {
+	flags.StringVar(username, "e", "", "Email")
+	flags.StringVar(username, "email", "", "Email")
+	flags.StringVar(password, "p", "", "Password")
+	flags.StringVar(password, "password", "", "Password")
+}
+
+func promptCredentials(username, password *string) {
+	if *username == "" {
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Print("Enter username (email): ")
+		bytes, _, _ := reader.ReadLine()
+		*username = string(bytes)
 	}
-	
-	if !foundCodes {
-		fmt.Println("No new VIP codes at this time. Try again later.")
-	} else {
-		folders := configDirs.QueryFolders(configdir.Global)
-		data, err := json.Marshal(&redeemedCodes)
-		if err == nil {
-			folders[0].WriteFile(configFilename, data)
-		}
+	if *password == "" {
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Print("Enter password        : ")
+		bytes, _, _ := reader.ReadLine()
+		*password = string(bytes)
+	}
+}
+
+// setupClient loads the config and logs in
+func setupClient(username, password string) (*bl3.Bl3Client, error) {
+	client, err := loadClient()
+	if err != nil {
+		return nil, err
+	}
+	if err := loginClient(client, username, password); err != nil {
+		return nil, err
 	}
+	return client, nil
 }
 
-func doShift(client *bl3.Bl3Client, singleShiftCode string) {
-	fmt.Print("Getting SHIFT platforms . . . . . ")
-	platforms, err := client.GetShiftPlatforms()
+// loadClient loads the config
+func loadClient() (*bl3.Bl3Client, error) {
+	fmt.Print("Setting up . . . . . ")
+	client, err := bl3.NewBl3Client()
 	if err != nil {
 		printError(err)
-		return
+		return nil, err
 	}
 	fmt.Println("success!")
 
-	configDirs := configdir.New("bl3-auto-vip", "bl3-auto-vip")
-	configFilename := usernameHash + "-shift-codes.json"
-	redeemedCodes := bl3.ShiftCodeMap{}
-
-	fmt.Print("Getting previously redeemed SHIFT codes . . . . . ")
-	folder := configDirs.QueryFolderContainsFile(configFilename)
-	if folder != nil {
-		data, err := folder.ReadFile(configFilename)
-		if err == nil {
-			json := bl3.JsonFromBytes(data)
-			if json != nil {
-				json.Out(&redeemedCodes)
-				fmt.Println("success!")
-			} else {
-				fmt.Println("not found.")
-			}
-		} else {
-			fmt.Println("not found.")
-		}
-	} else {
-		fmt.Println("not found.")
+	if client.Config.Version != version {
+		fmt.Println("Your version (" + version + ") is out of date. Please consider downloading the latest version (" + client.Config.Version + ") at https://github.com/matt1484/bl3_auto_vip/releases/latest")
 	}
+	return client, nil
+}
 
-	shiftCodes := bl3.ShiftCodeMap{}
+// loginClient logs in and discovers the VIP code types
+func loginClient(client *bl3.Bl3Client, username, password string) error {
+	fmt.Print("Logging in as '" + username + "' . . . . . ")
+	err := client.Login(username, password)
+	if err != nil {
+		printError(err)
+		return err
+	}
+	fmt.Println("success!")
 
-	if singleShiftCode != "" {
-		singleShiftCode = strings.TrimSpace(strings.ToUpper(singleShiftCode))
-		fmt.Print("Checking single SHIFT code '" + singleShiftCode + "' . . . . . ")
-		platforms, valid := client.GetCodePlatforms(singleShiftCode)
-		if valid {
-			shiftCodes[singleShiftCode] = platforms
-			fmt.Println("success!")
-		} else {
-			fmt.Println("no available redemption platforms found!")
-		}
+	// 2K changes the campaign ids now and then, the configured ones are only a fallback
+	fmt.Print("Discovering VIP code types . . . . . ")
+	warnings, err := client.DiscoverVipCodeUrls(configPath("vip-code-types.json"), vipDiscoveryTTL)
+	if err != nil {
+		fmt.Println("failed! Using the configured ones.")
 	} else {
-		fmt.Print("Getting new SHIFT codes . . . . . ")
-		allShiftCodes, err := client.GetFullShiftCodeList()
-		if err != nil {
-			printError(err)
-			return
-		}
-		shiftCodes = allShiftCodes
 		fmt.Println("success!")
 	}
-
-	foundCodes := false
-	for code, codePlatforms := range shiftCodes {
-		for _, platform := range codePlatforms {
-			if _, found := platforms[platform]; found {
-				if !redeemedCodes.Contains(code, platform) {
-					foundCodes = true
-					fmt.Print("Trying '" + platform + "' SHIFT code '" + code + "' . . . . . ")
-					err := client.RedeemShiftCode(code, platform)
-					if err != nil {
-						fmt.Println(err)
-						if strings.Contains(strings.ToLower(err.Error()), "already") {
-							redeemedCodes[code] = append(redeemedCodes[code], platform)
-						} else if strings.Contains(strings.ToLower(err.Error()), "has expired") {
-							redeemedCodes[code] = append(redeemedCodes[code], platform)
-						}
-					} else {
-						redeemedCodes[code] = append(redeemedCodes[code], platform)
-						fmt.Println("success!")
-					}
-				} else if singleShiftCode != "" {
-					fmt.Println("The single SHIFT code has already been redeemed on the '" + platform + "' platform")
-					foundCodes = true
-				}
-			}
-		}
+	for _, warning := range warnings {
+		fmt.Println("Warning: " + warning)
 	}
+	return nil
+}
 
-	if !foundCodes && singleShiftCode != "" {
-		fmt.Println("The single SHIFT code could not be redeemed at this time. Try again later.")
-	} else if !foundCodes {
-		fmt.Println("No new SHIFT codes at this time. Try again later.")
-	} else {
-		folders := configDirs.QueryFolders(configdir.Global)
-		data, err := json.Marshal(&redeemedCodes)
-		if err == nil {
-			folders[0].WriteFile(configFilename, data)
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "history":
+			if err := doHistory(os.Args[2:]); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		case "cache":
+			if err := doCache(os.Args[2:]); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		case "vip":
+			if err := doVip(os.Args[2:]); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		case "serve":
+			if err := doServe(os.Args[2:]); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		case "doctor":
+			if err := doDoctor(os.Args[2:]); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
 		}
 	}
-	
-}
 
-func main() {
 	username := ""
 	password := ""
 	singleShiftCode := ""
+	vipCodes := listFlag{}
+	codesFile := ""
+	codesText := ""
 	allowInactive := false
-	flag.StringVar(&username, "e", "", "Email")
-	flag.StringVar(&username, "email", "", "Email")
-	flag.StringVar(&password, "p", "", "Password")
-	flag.StringVar(&password, "password", "", "Password")
+	shiftGames := ""
+	onlyStages := ""
+	skipStages := ""
+	vipPageSize := 0
+	recheck := false
+	addCredentialFlags(flag.CommandLine, &username, &password)
+	activityIncludes, activityExcludes := addActivityRuleFlags(flag.CommandLine)
 	flag.StringVar(&singleShiftCode, "shift-code", "", "Single SHIFT code to redeem")
+	flag.Var(&vipCodes, "vip-code", "VIP code to redeem as type:code, the type is detected when left out (repeatable)")
+	flag.StringVar(&codesFile, "codes-file", "", "File with SHIFT and VIP codes to redeem, one per line or in free text")
+	flag.StringVar(&codesText, "codes", "", "SHIFT and VIP codes to redeem, or - to read them from stdin")
 	flag.BoolVar(&allowInactive, "allow-inactive", false, "Attempt to redeem SHIFT codes even if they are inactive?")
+	flag.StringVar(&shiftGames, "games", "", "Comma separated games to redeem SHIFT codes for (codenames or names, e.g. oak,willow2)")
+	flag.StringVar(&onlyStages, "only", "", "Comma separated stages to run (" + strings.Join(allStages, ", ") + ")")
+	flag.StringVar(&skipStages, "skip", "", "Comma separated stages to skip (" + strings.Join(allStages, ", ") + ")")
+	flag.IntVar(&vipPageSize, "vip-page-size", 0, "How many redeemed VIP codes to request from the server at a time (default " + strconv.Itoa(bl3.DefaultVipActivityPageSize) + ")")
+	flag.BoolVar(&recheck, "recheck", false, "Check codes again even if they did not work recently or were checked before")
 	flag.Parse()
 
-	if username == "" {
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Print("Enter username (email): ")
-		bytes, _, _ := reader.ReadLine()
-		username = string(bytes)
+	if codesText == "-" && (username == "" || password == "") {
+		fmt.Println("The email and password flags are required when reading codes from stdin")
+		return
 	}
-	if password == "" {
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Print("Enter password        : ")
-		bytes, _, _ := reader.ReadLine()
-		password = string(bytes)
+	// checked before anything is downloaded, manual codes can only narrow these down
+	if stages, err := selectStages(onlyStages, skipStages); err != nil {
+		fmt.Println(err)
+		return
+	} else if len(stages) == 0 {
+		fmt.Println("Every stage is skipped, there is nothing to do")
+		return
+	}
+	// the config knows the VIP code types needed to read the codes
+	client, err := loadClient()
+	if err != nil {
+		return
+	}
+	opts, err := readManualCodes(singleShiftCode, vipCodes, codesFile, codesText, client.Config.Vip.GetCodeTypes())
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
 
-	hasher := md5.New()
-    hasher.Write([]byte(username))
-	usernameHash = hex.EncodeToString(hasher.Sum(nil))
-
-	fmt.Print("Setting up . . . . . ")
-	client, err := bl3.NewBl3Client()
+	// a single SHIFT code used to imply skipping everything else, keep that as the default
+	if onlyStages == "" {
+		manualStages := make([]string, 0)
+		if len(opts.shiftCodes) > 0 {
+			manualStages = append(manualStages, stageShift)
+		}
+		if len(opts.vipCodes) > 0 {
+			manualStages = append(manualStages, stageVipCodes)
+		}
+		onlyStages = strings.Join(manualStages, ",")
+	}
+	stages, err := selectStages(onlyStages, skipStages)
 	if err != nil {
-		printError(err)
+		fmt.Println(err)
+		return
+	}
+	if len(stages) == 0 {
+		fmt.Println("The stages of the given codes are skipped, there is nothing to do")
 		return
 	}
 
+	promptCredentials(&username, &password)
+	if err := loginClient(client, username, password); err != nil {
+		return
+	}
+	account := hashUsername(username)
 	client.Config.Shift.AllowInactive = allowInactive
-
-	fmt.Println("success!")
-
-	if client.Config.Version != version {
-		fmt.Println("Your version (" + version + ") is out of date. Please consider downloading the latest version (" + client.Config.Version + ") at https://github.com/matt1484/bl3_auto_vip/releases/latest")
+	if vipPageSize > 0 {
+		client.Config.Vip.ActivityPageSize = vipPageSize
+	}
+	if err := selectShiftGames(&client.Config.Shift, shiftGames); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := applyActivityRules(&client.Config.Vip, *activityIncludes, *activityExcludes); err != nil {
+		fmt.Println(err)
+		return
 	}
 
-	fmt.Print("Logging in as '" + username + "' . . . . . ")
-	err = client.Login(username, password)
+	store, err := openStore()
 	if err != nil {
 		printError(err)
 		return
 	}
-	fmt.Println("success!")
+	defer store.Close()
+
+	if err := migrateLegacyCaches(store, &client.Config, account); err != nil {
+		fmt.Println("Failed to migrate old code caches: " + err.Error())
+	}
 
-	doShift(client, singleShiftCode)
+	client.NegativeCache, err = openNegativeCache()
+	if err != nil {
+		fmt.Println("Failed to open the cache of bad codes, checking every code: " + err.Error())
+	} else {
+		client.NegativeCache.Recheck = recheck
+	}
+	client.ShiftCodeIndex, err = openShiftCodeIndex()
+	if err != nil {
+		fmt.Println("Failed to open the SHIFT code index, checking every code: " + err.Error())
+	} else {
+		client.ShiftCodeIndex.Recheck = recheck
+	}
 
-	if singleShiftCode == "" {
-		doVip(client)
+	report := newRunner(client, store, account, os.Stdout).run(stages, opts)
+	if len(opts.shiftCodes) > 0 || len(opts.vipCodes) > 0 {
+		printCodeResults(report)
 	}
 
 	exit()
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelectStages(t *testing.T) {
+	tests := []struct {
+		only    string
+		skip    string
+		want    []string
+		wantErr bool
+	}{
+		{"", "", []string{stageShift, stageVipActivities, stageVipCodes}, false},
+		{"vip-codes,shift", "", []string{stageShift, stageVipCodes}, false},
+		{" Shift , shift,", "", []string{stageShift}, false},
+		{"", "vip-activities", []string{stageShift, stageVipCodes}, false},
+		{"shift,vip-codes", "vip-codes", []string{stageShift}, false},
+		{"", "shift,vip-activities,vip-codes", []string{}, false},
+		{"shift", "shift", []string{}, false},
+		{"shift,vip", "", nil, true},
+		{"", "activities", nil, true},
+	}
+	for _, test := range tests {
+		got, err := selectStages(test.only, test.skip)
+		if (err != nil) != test.wantErr {
+			t.Errorf("selectStages(%q, %q) error = %v, want error %v", test.only, test.skip, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("selectStages(%q, %q) = %q, want %q", test.only, test.skip, got, test.want)
+		}
+	}
+}
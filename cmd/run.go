@@ -0,0 +1,636 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bl3 "github.com/matt1484/bl3_auto_vip"
+)
+
+const (
+	stageShift         = "shift"
+	stageVipCodes      = "vip-codes"
+	stageVipActivities = "vip-activities"
+)
+
+// the order stages run in
+var allStages = []string{stageShift, stageVipActivities, stageVipCodes}
+
+type activityReport struct {
+	Title   string `json:"title"`
+	Claimed bool   `json:"claimed"`
+	Error   string `json:"error,omitempty"`
+	// NextAvailable is set for capped activities that were not tried
+	NextAvailable *time.Time `json:"nextAvailable,omitempty"`
+}
+
+// skippedCode is a code given by the user that was not tried
+type skippedCode struct {
+	Code   string `json:"code"`
+	Kind   string `json:"kind"`
+	Reason string `json:"reason"`
+}
+
+type stageReport struct {
+	Stage       string                 `json:"stage"`
+	Error       string                 `json:"error,omitempty"`
+	Redemptions []bl3.RedemptionRecord `json:"redemptions"`
+	Skipped     []skippedCode          `json:"skipped,omitempty"`
+	Activities  []activityReport       `json:"activities,omitempty"`
+}
+
+type runReport struct {
+	Account  string        `json:"account"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Stages   []stageReport `json:"stages"`
+	// PointDelta is the VIP points earned during the run, when a VIP stage ran
+	PointDelta *int   `json:"pointDelta,omitempty"`
+	Log        string `json:"log,omitempty"`
+}
+
+func (report *runReport) succeeded() bool {
+	for _, stage := range report.Stages {
+		if stage.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// runner runs stages for a single logged in account and collects what happened
+type runner struct {
+	client  *bl3.Bl3Client
+	store   bl3.RedemptionStore
+	account string
+	out     io.Writer
+	stage   *stageReport
+}
+
+func newRunner(client *bl3.Bl3Client, store bl3.RedemptionStore, account string, out io.Writer) *runner {
+	return &runner{
+		client:  client,
+		store:   store,
+		account: account,
+		out:     out,
+		stage:   &stageReport{},
+	}
+}
+
+func (r *runner) print(a ...interface{}) {
+	fmt.Fprint(r.out, a...)
+}
+
+func (r *runner) println(a ...interface{}) {
+	fmt.Fprintln(r.out, a...)
+}
+
+func (r *runner) printError(err error) {
+	r.println("failed!")
+	r.print("Had error: ")
+	r.println(err)
+	if shapeErr, ok := err.(*bl3.ErrUnexpectedResponseShape); ok {
+		r.println("The API may have changed, the response was: " + responseExcerpt(shapeErr.Payload))
+	}
+}
+
+// runOptions are codes given by the user instead of the code lists
+type runOptions struct {
+	shiftCodes []string
+	// VIP codes as type:code or just code when the type is unknown
+	vipCodes []string
+}
+
+// run runs each stage in order, a failing stage does not stop the ones after it
+func (r *runner) run(stages []string, opts runOptions) *runReport {
+	report := &runReport{
+		Account: r.account,
+		Started: time.Now(),
+		Stages:  make([]stageReport, 0, len(stages)),
+	}
+	pointsBefore, pointsErr := 0, errors.New("no VIP stage")
+	for _, stage := range stages {
+		if stage == stageVipCodes || stage == stageVipActivities {
+			pointsBefore, pointsErr = r.vipPoints()
+			break
+		}
+	}
+
+	for _, stage := range stages {
+		r.stage = &stageReport{Stage: stage, Redemptions: make([]bl3.RedemptionRecord, 0)}
+		var err error
+		switch stage {
+		case stageShift:
+			err = r.doShift(opts.shiftCodes)
+		case stageVipCodes:
+			if len(opts.vipCodes) > 0 {
+				err = r.doManualVipCodes(opts.vipCodes)
+			} else {
+				err = r.doVipCodes()
+			}
+		case stageVipActivities:
+			err = r.doVipActivities()
+		}
+		if err != nil {
+			r.stage.Error = err.Error()
+		}
+		report.Stages = append(report.Stages, *r.stage)
+	}
+	report.Finished = time.Now()
+	if pointsErr == nil {
+		if pointsAfter, err := r.vipPoints(); err == nil {
+			delta := pointsAfter - pointsBefore
+			report.PointDelta = &delta
+		}
+	}
+	if report.succeeded() {
+		bl3.MetricLastSuccessfulRun.Set(float64(report.Finished.Unix()), r.account)
+	}
+
+	if len(stages) > 1 || report.PointDelta != nil {
+		r.println("Summary:")
+		for _, stage := range report.Stages {
+			r.print("  " + stage.Stage + " . . . . . ")
+			if stage.Error != "" {
+				r.println("failed! (" + stage.Error + ")")
+			} else {
+				r.println("success!")
+			}
+		}
+		if report.PointDelta != nil {
+			r.println("  VIP points earned: " + strconv.Itoa(*report.PointDelta))
+		}
+	}
+	return report
+}
+
+func (r *runner) vipPoints() (int, error) {
+	status, err := r.client.GetVipStatus(0)
+	return status.Points, err
+}
+
+func (r *runner) doVipActivities() error {
+	r.print("Getting available VIP activities (excluding codes) . . . . . ")
+	activities, err := r.client.GetVipActivities()
+	if err != nil {
+		r.printError(err)
+		return err
+	}
+	r.println("success!")
+	foundActivities := false
+	failed := 0
+	claimable := make([]bl3.VipActivity, 0)
+	for _, activity := range activities {
+		if claim, _ := r.client.Config.Vip.ClaimsActivity(activity); claim {
+			claimable = append(claimable, activity)
+		}
+	}
+	for _, activity := range claimable {
+		if activity.Capped {
+			next := activity.NextAvailable
+			r.stage.Activities = append(r.stage.Activities, activityReport{Title: activity.Title, NextAvailable: &next})
+		} else {
+			foundActivities = true
+			r.print("Trying VIP activity '" + activity.Title + "' . . . . . ")
+			err := r.client.RedeemVipActivity(activity)
+			report := activityReport{Title: activity.Title, Claimed: err == nil}
+			if err != nil {
+				report.Error = err.Error()
+				failed++
+				r.println("failed! (" + err.Error() + ")")
+			} else {
+				r.println("success!")
+			}
+			r.stage.Activities = append(r.stage.Activities, report)
+		}
+	}
+	if !foundActivities {
+		next := bl3.NextVipActivityTime(claimable, time.Now())
+		if next.IsZero() {
+			r.println("No new VIP activities at this time. Try again later.")
+		} else {
+			r.println("No new VIP activities at this time. Try again after " + next.Local().Format("Mon Jan 2 15:04 MST") + ".")
+		}
+	}
+	if failed > 0 {
+		return errors.New(strconv.Itoa(failed) + " VIP activities were not credited")
+	}
+	return nil
+}
+
+func (r *runner) doVipCodes() error {
+	r.print("Getting previously redeemed VIP codes . . . . . ")
+	redeemedCodesCached, err := r.client.Config.RedeemedVipCodes(r.store, r.account)
+	if err != nil {
+		r.printError(err)
+		return err
+	}
+	// the server only needs to be asked about what happened since the newest local redemption
+	since, err := bl3.NewestRecordTime(r.store, r.account, bl3.KindVip)
+	if err != nil {
+		r.printError(err)
+		return err
+	}
+	serverCodes := r.client.Config.NewVipCodeMap()
+	serverTimes := bl3.VipCodeTimes{}
+	undated, err := r.client.AddRedeemedVipCodes(serverCodes, serverTimes, since)
+	if err != nil {
+		r.printError(err)
+		return err
+	}
+	r.println("success!")
+	if undated > 0 && !since.IsZero() {
+		r.println("  " + strconv.Itoa(undated) + " server activities have a time that can not be read, so all of them were fetched.")
+	}
+	// saved so the next run can stop before them, like cache reconcile does
+	redeemedCodes := redeemedCodesCached
+	for codeType, codes := range serverCodes.Diff(redeemedCodesCached) {
+		for code := range codes {
+			// not an attempt of this run, so only the history gets it
+			err := r.store.Add(bl3.RedemptionRecord{
+				Account:  r.account,
+				Code:     code,
+				Kind:     bl3.KindVip,
+				Platform: codeType,
+				Result:   bl3.ResultRedeemed,
+				Message:  "found in server redemption history",
+				Time:     serverTimes.Get(codeType, code),
+				Source:   bl3.SourceServer,
+			})
+			if err != nil {
+				r.println("Failed to save redemption history: " + err.Error())
+			}
+			redeemedCodes.Add(codeType, code)
+		}
+	}
+
+	r.print("Getting new VIP codes . . . . . ")
+	allCodes, tables, err := r.client.ScrapeVipCodeList()
+	if err != nil {
+		r.printError(err)
+		return err
+	}
+	r.println("success!")
+	for _, table := range tables {
+		r.println("  Read " + table.String())
+	}
+
+	newCodes := allCodes.Diff(redeemedCodes)
+	foundCodes := false
+	for codeType, codes := range newCodes {
+		if len(codes) < 1 {
+			continue
+		}
+		foundCodes = true
+		r.print("Setting up VIP codes of type '" + codeType + "' . . . . . ")
+		_, found := r.client.Config.Vip.CodeTypeUrlMap[codeType]
+		if !found {
+			r.println("invalid! Moving on.")
+			continue
+		}
+		r.println("success!")
+
+		for code := range codes {
+			if r.knownBadVipCode(codeType, code) {
+				continue
+			}
+			r.print("Trying '" + codeType + "' VIP code '" + code + "' . . . . . ")
+			res, valid := r.client.RedeemVipCode(codeType, code)
+			result := bl3.VipRedemptionResult(res, valid)
+			r.rememberVipResult(codeType, code, res, result)
+			r.recordRedemption(bl3.RedemptionRecord{
+				Code:     code,
+				Kind:     bl3.KindVip,
+				Platform: codeType,
+				Result:   result,
+				Message:  res,
+				Source:   bl3.SourceVipCodeList,
+			})
+			if !valid {
+				r.println("failed! Moving on.")
+				continue
+			}
+			r.println(res)
+		}
+	}
+
+	if !foundCodes {
+		r.println("No new VIP codes at this time. Try again later.")
+	}
+	return nil
+}
+
+func (r *runner) doManualVipCodes(vipCodes []string) error {
+	r.print("Getting previously redeemed VIP codes . . . . . ")
+	redeemedCodes, err := r.client.Config.RedeemedVipCodes(r.store, r.account)
+	if err != nil {
+		r.printError(err)
+		return err
+	}
+	r.println("success!")
+
+	codeTypes := r.client.Config.Vip.GetCodeTypes()
+	sort.Strings(codeTypes)
+	failed := 0
+	for _, vipCode := range vipCodes {
+		codeType, code := "", strings.TrimSpace(vipCode)
+		if i := strings.Index(code, ":"); i >= 0 {
+			codeType, code = strings.ToLower(strings.TrimSpace(code[:i])), strings.TrimSpace(code[i+1:])
+		}
+		code = strings.ToLower(code)
+
+		candidates := codeTypes
+		if codeType != "" {
+			if _, found := r.client.Config.Vip.CodeTypeUrlMap[codeType]; !found {
+				r.println("Unknown VIP code type '" + codeType + "' for code '" + code + "'. Moving on.")
+				r.skip(code, bl3.KindVip, "unknown type "+codeType)
+				failed++
+				continue
+			}
+			candidates = []string{codeType}
+		} else if detected := r.client.Config.Vip.DetectCodeTypes(vipCode); len(detected) > 0 {
+			candidates = detected
+		}
+
+		redeemed := false
+		for _, candidate := range candidates {
+			if _, found := redeemedCodes[candidate][code]; found {
+				r.println("The '" + candidate + "' VIP code '" + code + "' has already been redeemed.")
+				r.skip(code, bl3.KindVip, "already redeemed as "+candidate)
+				redeemed = true
+				break
+			}
+			if r.knownBadVipCode(candidate, code) {
+				continue
+			}
+			r.print("Trying '" + candidate + "' VIP code '" + code + "' . . . . . ")
+			res, valid := r.client.RedeemVipCode(candidate, code)
+			result := bl3.VipRedemptionResult(res, valid)
+			r.rememberVipResult(candidate, code, res, result)
+			r.recordRedemption(bl3.RedemptionRecord{
+				Code:     code,
+				Kind:     bl3.KindVip,
+				Platform: candidate,
+				Result:   result,
+				Message:  res,
+				Source:   bl3.SourceManual,
+			})
+			if valid {
+				r.println(res)
+				redeemedCodes.Add(candidate, code)
+				redeemed = true
+				break
+			}
+			r.println("failed! (" + res + ")")
+			// an invalid code might just be the wrong type, anything else will not get better
+			if result != bl3.ResultInvalid {
+				break
+			}
+		}
+		if !redeemed {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return errors.New(strconv.Itoa(failed) + " of " + strconv.Itoa(len(vipCodes)) + " VIP codes could not be redeemed")
+	}
+	return nil
+}
+
+// doShift redeems the given SHIFT codes, or the codes from the code lists when there are none
+func (r *runner) doShift(manualCodes []string) error {
+	r.print("Getting SHIFT platforms . . . . . ")
+	platforms, err := r.client.GetShiftPlatforms()
+	if err != nil {
+		r.printError(err)
+		return err
+	}
+	r.println("success!")
+
+	r.print("Getting previously redeemed SHIFT codes . . . . . ")
+	redeemedCodes, err := bl3.RedeemedShiftCodes(r.store, r.account)
+	if err != nil {
+		r.printError(err)
+		return err
+	}
+	if len(redeemedCodes) > 0 {
+		r.println("success!")
+	} else {
+		r.println("not found.")
+	}
+
+	shiftCodes := bl3.ShiftGameCodeMap{}
+	source := bl3.SourceShiftFeed
+	// what the code lists say about each code, for the history
+	feedCodes := make(map[string]bl3.ShiftFeedCode)
+	manual := len(manualCodes) > 0
+
+	if manual {
+		source = bl3.SourceManual
+		for _, code := range manualCodes {
+			if normalized := bl3.NormalizeShiftCode(code); normalized != "" {
+				code = normalized
+			} else {
+				code = strings.TrimSpace(strings.ToUpper(code))
+			}
+			r.print("Checking SHIFT code '" + code + "' . . . . . ")
+			gamePlatforms, err := r.client.GetCodeGamePlatforms(code)
+			if err == bl3.ErrNoCodePlatforms {
+				r.println("no available redemption platforms found!")
+				r.skip(code, bl3.KindShift, "no available redemption platforms")
+				continue
+			}
+			if rejected, ok := err.(*bl3.ErrCodeRejected); ok && rejected.Cached {
+				r.println("skipped, " + rejected.Error() + ". Use --recheck to try it anyway.")
+				r.skip(code, bl3.KindShift, "known bad: "+rejected.Reason)
+				continue
+			}
+			if err != nil {
+				r.printError(err)
+				r.skip(code, bl3.KindShift, err.Error())
+				continue
+			}
+			for game, platforms := range gamePlatforms {
+				if _, found := shiftCodes[game]; !found {
+					shiftCodes[game] = bl3.ShiftCodeMap{}
+				}
+				shiftCodes[game][code] = platforms
+			}
+			r.println("success!")
+		}
+	} else {
+		r.print("Getting new SHIFT codes . . . . . ")
+		feed, feedErrors, err := r.client.GetShiftCodeFeed()
+		if err != nil {
+			r.printError(err)
+			return err
+		}
+		now := time.Now()
+		expired := bl3.StringSet{}
+		for _, code := range feed {
+			if code.Expired(now) {
+				expired.Add(code.Code)
+			} else if _, found := feedCodes[code.Code]; !found {
+				feedCodes[code.Code] = code
+			}
+		}
+		var stats bl3.ShiftLookupStats
+		shiftCodes, stats, err = r.client.GetShiftCodePlatforms(feed)
+		if err != nil {
+			r.printError(err)
+			return err
+		}
+		r.println("success!")
+		// the other games still get their codes when one code list is down
+		for _, game := range r.client.Config.Shift.GetGames() {
+			if err, found := feedErrors[game.Codename]; found {
+				r.println("Could not get the SHIFT codes for " + game.Name + ": " + err.Error())
+				if shapeErr, ok := err.(*bl3.ErrUnexpectedResponseShape); ok {
+					r.println("The code list may have changed, the response was: " + responseExcerpt(shapeErr.Payload))
+				}
+			}
+		}
+		if r.client.ShiftCodeIndex != nil {
+			r.println("Looked up " + strconv.Itoa(stats.Looked) + " new or stale SHIFT codes, reused " + strconv.Itoa(stats.Reused) + " checked before.")
+		}
+		if len(expired) > 0 {
+			r.println("Skipped " + strconv.Itoa(len(expired)) + " expired SHIFT codes.")
+		}
+		// expired codes are no longer worth comparing against, and only their redemptions are worth
+		// keeping in the history
+		for code := range expired {
+			delete(redeemedCodes, code)
+		}
+		pruned, err := r.store.Prune(func(record bl3.RedemptionRecord) bool {
+			_, isExpired := expired[record.Code]
+			return record.Kind == bl3.KindShift && !record.Done() && (isExpired || record.Expired(now))
+		})
+		if err != nil {
+			r.println("Failed to prune expired SHIFT codes: " + err.Error())
+		} else if pruned > 0 {
+			r.println("Pruned " + strconv.Itoa(pruned) + " failed attempts of expired SHIFT codes from the redemption history.")
+		}
+	}
+
+	foundCodes := false
+	for _, game := range r.client.Config.Shift.GetGames() {
+		codes := shiftCodes[game.Codename]
+		if len(codes) == 0 {
+			continue
+		}
+		r.println("SHIFT codes for " + r.client.Config.Shift.GameName(game.Codename) + ":")
+		foundGameCodes := false
+		for _, code := range sortedShiftCodes(codes) {
+			linked := false
+			for _, platform := range codes[code] {
+				if _, found := platforms[platform]; !found {
+					continue
+				}
+				linked = true
+				if !redeemedCodes.Contains(code, platform) {
+					foundGameCodes = true
+					r.print("Trying '" + platform + "' SHIFT code '" + code + "' . . . . . ")
+					err := r.client.RedeemShiftCode(code, platform)
+					record := bl3.RedemptionRecord{
+						Code:     code,
+						Kind:     bl3.KindShift,
+						Platform: platform,
+						Game:     game.Codename,
+						Result:   bl3.ShiftRedemptionResult(err),
+						Source:   source,
+					}
+					if feedCode, found := feedCodes[code]; found {
+						record.Reward = feedCode.Reward
+						if !feedCode.Expires.IsZero() {
+							expires := feedCode.Expires
+							record.Expires = &expires
+						}
+					}
+					if err != nil {
+						record.Message = err.Error()
+						r.println(err)
+					} else {
+						r.println("success!")
+					}
+					r.recordRedemption(record)
+					// the same code can show up for more than one game
+					if record.Done() {
+						redeemedCodes[code] = append(redeemedCodes[code], platform)
+					}
+				} else if manual {
+					r.println("The SHIFT code '" + code + "' has already been redeemed on the '" + platform + "' platform")
+					r.skip(code, bl3.KindShift, "already redeemed on "+platform)
+					foundGameCodes = true
+				}
+			}
+			if !linked && manual {
+				r.skip(code, bl3.KindShift, "not available on any linked platform")
+			}
+		}
+		if !foundGameCodes && !manual {
+			r.println("No new SHIFT codes for " + r.client.Config.Shift.GameName(game.Codename) + " at this time.")
+		}
+		foundCodes = foundCodes || foundGameCodes
+	}
+
+	if !foundCodes && manual {
+		r.println("The SHIFT codes could not be redeemed at this time. Try again later.")
+	} else if !foundCodes {
+		r.println("No new SHIFT codes at this time. Try again later.")
+	}
+	return nil
+}
+
+func sortedShiftCodes(codeMap bl3.ShiftCodeMap) []string {
+	codes := make([]string, 0, len(codeMap))
+	for code := range codeMap {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func (r *runner) skip(code, kind, reason string) {
+	r.stage.Skipped = append(r.stage.Skipped, skippedCode{Code: code, Kind: kind, Reason: reason})
+}
+
+// knownBadVipCode skips a code the negative cache says did not work for the code type
+func (r *runner) knownBadVipCode(codeType, code string) bool {
+	entry, found := r.client.NegativeCache.Get(bl3.KindVip, codeType, code)
+	if !found {
+		return false
+	}
+	r.println("Skipping '" + codeType + "' VIP code '" + code + "', " + entry.Reason + " (checked before).")
+	r.skip(code, bl3.KindVip, "known bad as "+codeType+": "+entry.Reason)
+	return true
+}
+
+// rememberVipResult updates the negative cache, only codes the server rejected are remembered
+func (r *runner) rememberVipResult(codeType, code, message, result string) {
+	cache := r.client.NegativeCache
+	var err error
+	switch result {
+	case bl3.ResultInvalid, bl3.ResultExpired:
+		err = cache.Add(bl3.KindVip, codeType, code, message, bl3.NegativeTtlInvalid)
+	case bl3.ResultRedeemed, bl3.ResultAlreadyRedeemed:
+		err = cache.Remove(bl3.KindVip, codeType, code)
+	}
+	if err != nil {
+		r.println("Failed to save code cache: " + err.Error())
+	}
+}
+
+func (r *runner) recordRedemption(record bl3.RedemptionRecord) {
+	record.Account = r.account
+	if err := r.store.Add(record); err != nil {
+		r.println("Failed to save redemption history: " + err.Error())
+	}
+	r.stage.Redemptions = append(r.stage.Redemptions, record)
+	bl3.MetricRedemptionAttempts.Inc(record.Kind, record.Platform, record.Result)
+}
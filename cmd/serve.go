@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	bl3 "github.com/matt1484/bl3_auto_vip"
+)
+
+// apiAccount is a logged in account, mu makes sure only one redemption runs at a time for it
+type apiAccount struct {
+	email string
+	// password is kept to log in again when the session expires
+	password   string
+	hash       string
+	client     *bl3.Bl3Client
+	mu         sync.Mutex
+	lastReport *runReport
+}
+
+type apiServer struct {
+	token    string
+	store    bl3.RedemptionStore
+	accounts map[string]*apiAccount
+	// the first account, used when a request does not name one
+	defaultAccount *apiAccount
+}
+
+// accountFlags collects repeated --account email:password flags
+type accountFlags []string
+
+func (a *accountFlags) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *accountFlags) Set(s string) error {
+	if !strings.Contains(s, ":") {
+		return errors.New("expected email:password")
+	}
+	*a = append(*a, s)
+	return nil
+}
+
+func doServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	username, password := "", ""
+	addCredentialFlags(flags, &username, &password)
+	extraAccounts := accountFlags{}
+	flags.Var(&extraAccounts, "account", "Additional account as email:password (repeatable)")
+	listen := flags.String("listen", "127.0.0.1:8080", "Address to listen on")
+	token := flags.String("token", os.Getenv("BL3_API_TOKEN"), "Token clients must send as 'Authorization: Bearer <token>' (defaults to $BL3_API_TOKEN)")
+	metricsListen := flags.String("metrics-listen", "", "Address to serve Prometheus metrics on at /metrics (disabled when empty)")
+	shiftGames := flags.String("games", "", "Comma separated games to redeem SHIFT codes for (codenames or names, e.g. oak,willow2)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	credentials := make([][2]string, 0)
+	if username != "" || len(extraAccounts) == 0 {
+		promptCredentials(&username, &password)
+		credentials = append(credentials, [2]string{username, password})
+	}
+	for _, account := range extraAccounts {
+		// emails can not contain ':' but passwords can
+		parts := strings.SplitN(account, ":", 2)
+		credentials = append(credentials, [2]string{parts[0], parts[1]})
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	negativeCache, err := openNegativeCache()
+	if err != nil {
+		fmt.Println("Failed to open the cache of bad codes, checking every code: " + err.Error())
+	}
+	shiftCodeIndex, err := openShiftCodeIndex()
+	if err != nil {
+		fmt.Println("Failed to open the SHIFT code index, checking every code: " + err.Error())
+	}
+
+	server := &apiServer{
+		token:    *token,
+		store:    store,
+		accounts: make(map[string]*apiAccount),
+	}
+	for _, credential := range credentials {
+		client, err := setupClient(credential[0], credential[1])
+		if err != nil {
+			return err
+		}
+		if err := selectShiftGames(&client.Config.Shift, *shiftGames); err != nil {
+			return err
+		}
+		client.NegativeCache = negativeCache
+		client.ShiftCodeIndex = shiftCodeIndex
+		account := &apiAccount{
+			email:    credential[0],
+			password: credential[1],
+			hash:     hashUsername(credential[0]),
+			client:   client,
+		}
+		if err := migrateLegacyCaches(store, &client.Config, account.hash); err != nil {
+			fmt.Println("Failed to migrate old code caches: " + err.Error())
+		}
+		server.accounts[strings.ToLower(account.email)] = account
+		if server.defaultAccount == nil {
+			server.defaultAccount = account
+		}
+	}
+
+	if server.token == "" {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		server.token = hex.EncodeToString(buf)
+		fmt.Println("No token given, generated one: " + server.token)
+	}
+
+	if *metricsListen != "" {
+		metrics := http.NewServeMux()
+		metrics.Handle("/metrics", bl3.MetricsHandler())
+		go func() {
+			fmt.Println("Serving metrics on http://" + *metricsListen + "/metrics")
+			if err := newHttpServer(*metricsListen, metrics).ListenAndServe(); err != nil {
+				fmt.Println("Failed to serve metrics: " + err.Error())
+			}
+		}()
+	}
+
+	fmt.Println("Listening on http://" + *listen)
+	return newHttpServer(*listen, server.routes()).ListenAndServe()
+}
+
+// newHttpServer limits how long a client can take to send a request. There is no write timeout,
+// a run can take minutes before the response is written.
+func newHttpServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+	}
+}
+
+func (server *apiServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shift-codes", server.handleShiftCode)
+	mux.HandleFunc("/runs", server.handleRun)
+	mux.HandleFunc("/platforms", server.handlePlatforms)
+	mux.HandleFunc("/history", server.handleHistory)
+	mux.HandleFunc("/report", server.handleReport)
+	return server.authenticate(mux)
+}
+
+func (server *apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header := req.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header || subtle.ConstantTimeCompare([]byte(token), []byte(server.token)) != 1 {
+			writeApiError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// account finds the account named by the account query parameter, or the default one
+func (server *apiServer) account(req *http.Request) (*apiAccount, error) {
+	email := req.URL.Query().Get("account")
+	if email == "" {
+		return server.defaultAccount, nil
+	}
+	account, found := server.accounts[strings.ToLower(email)]
+	if !found {
+		return nil, errors.New("unknown account '" + email + "'")
+	}
+	return account, nil
+}
+
+// relogin logs in again when the session no longer works, it expects the account lock to be held
+func (account *apiAccount) relogin() (bool, error) {
+	if _, err := account.client.GetShiftPlatforms(); err == nil {
+		return false, nil
+	}
+	fmt.Println("Session of '" + account.email + "' stopped working, logging in again")
+	if err := account.client.Login(account.email, account.password); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// runLocked runs the stages for the account, waiting for any other run of that account to finish first.
+// When a stage fails because the session expired it logs in again and runs the failed stages once more.
+func (server *apiServer) runLocked(account *apiAccount, stages []string, opts runOptions) *runReport {
+	account.mu.Lock()
+	defer account.mu.Unlock()
+
+	log := bytes.Buffer{}
+	report := newRunner(account.client, server.store, account.hash, &log).run(stages, opts)
+	if !report.succeeded() {
+		failed := make([]string, 0)
+		for _, stage := range report.Stages {
+			if stage.Error != "" {
+				failed = append(failed, stage.Stage)
+			}
+		}
+		relogged, err := account.relogin()
+		if err != nil {
+			fmt.Fprintln(&log, "Failed to log in again: "+err.Error())
+		} else if relogged {
+			fmt.Fprintln(&log, "Logged in again, retrying "+strings.Join(failed, ", ")+".")
+			retry := newRunner(account.client, server.store, account.hash, &log).run(failed, opts)
+			for _, retried := range retry.Stages {
+				for i := range report.Stages {
+					if report.Stages[i].Stage == retried.Stage {
+						report.Stages[i] = retried
+					}
+				}
+			}
+			report.Finished = retry.Finished
+			if report.PointDelta == nil {
+				report.PointDelta = retry.PointDelta
+			} else if retry.PointDelta != nil {
+				delta := *report.PointDelta + *retry.PointDelta
+				report.PointDelta = &delta
+			}
+		}
+	}
+	report.Log = log.String()
+	account.lastReport = report
+	return report
+}
+
+func (server *apiServer) handleShiftCode(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+	account, err := server.account(req)
+	if err != nil {
+		writeApiError(w, http.StatusNotFound, err)
+		return
+	}
+
+	body := struct {
+		Code string `json:"code"`
+	}{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil || strings.TrimSpace(body.Code) == "" {
+		writeApiError(w, http.StatusBadRequest, errors.New("expected {\"code\": \"<SHIFT code>\"}"))
+		return
+	}
+
+	writeApiJson(w, http.StatusOK, server.runLocked(account, []string{stageShift}, runOptions{shiftCodes: []string{body.Code}}))
+}
+
+func (server *apiServer) handleRun(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+	account, err := server.account(req)
+	if err != nil {
+		writeApiError(w, http.StatusNotFound, err)
+		return
+	}
+
+	body := struct {
+		Only []string `json:"only"`
+		Skip []string `json:"skip"`
+	}{}
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeApiError(w, http.StatusBadRequest, errors.New("expected {\"only\": [...], \"skip\": [...]}"))
+			return
+		}
+	}
+	stages, err := selectStages(strings.Join(body.Only, ","), strings.Join(body.Skip, ","))
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeApiJson(w, http.StatusOK, server.runLocked(account, stages, runOptions{}))
+}
+
+func (server *apiServer) handlePlatforms(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+	account, err := server.account(req)
+	if err != nil {
+		writeApiError(w, http.StatusNotFound, err)
+		return
+	}
+
+	account.mu.Lock()
+	platforms, err := account.client.GetShiftPlatforms()
+	if err != nil {
+		if relogged, loginErr := account.relogin(); loginErr == nil && relogged {
+			platforms, err = account.client.GetShiftPlatforms()
+		}
+	}
+	account.mu.Unlock()
+	if err != nil {
+		writeApiError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeApiJson(w, http.StatusOK, sortedCodes(platforms))
+}
+
+func (server *apiServer) handleHistory(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+	account, err := server.account(req)
+	if err != nil {
+		writeApiError(w, http.StatusNotFound, err)
+		return
+	}
+
+	query := req.URL.Query()
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	filterFlags := addRedemptionFilterFlags(flags)
+	args := make([]string, 0)
+	for _, name := range []string{"since", "until", "kind", "platform", "type", "result"} {
+		if value := query.Get(name); value != "" {
+			args = append(args, "-"+name+"="+value)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter, err := filterFlags.build()
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.Account = account.hash
+
+	records, err := server.store.Records(account.hash)
+	if err != nil {
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeApiJson(w, http.StatusOK, bl3.FilterRecords(records, filter))
+}
+
+func (server *apiServer) handleReport(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+	account, err := server.account(req)
+	if err != nil {
+		writeApiError(w, http.StatusNotFound, err)
+		return
+	}
+
+	account.mu.Lock()
+	report := account.lastReport
+	account.mu.Unlock()
+	if report == nil {
+		writeApiError(w, http.StatusNotFound, errors.New("nothing has run yet"))
+		return
+	}
+	writeApiJson(w, http.StatusOK, report)
+}
+
+func allowMethod(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		w.Header().Set("Allow", method)
+		writeApiError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return false
+	}
+	return true
+}
+
+func writeApiJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeApiError(w http.ResponseWriter, status int, err error) {
+	writeApiJson(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	server := &apiServer{token: "secret"}
+	handler := server.authenticate(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	tests := []struct {
+		header string
+		want   int
+	}{
+		{"Bearer secret", http.StatusNoContent},
+		{"secret", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic secret", http.StatusUnauthorized},
+		{"bearer secret", http.StatusUnauthorized},
+		{"Bearer ", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/report", nil)
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != test.want {
+			t.Errorf("Authorization %q = %d, want %d", test.header, w.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	bl3 "github.com/matt1484/bl3_auto_vip"
+	"github.com/shibukawa/configdir"
+)
+
+// configPath is where a file lives in the global config folder
+func configPath(filename string) string {
+	configDirs := configdir.New("bl3-auto-vip", "bl3-auto-vip")
+	folders := configDirs.QueryFolders(configdir.Global)
+	return filepath.Join(folders[0].Path, filename)
+}
+
+func openStore() (bl3.RedemptionStore, error) {
+	return bl3.OpenJsonlRedemptionStore(configPath("redemptions.jsonl"))
+}
+
+// openNegativeCache opens the codes that did not work recently, shared by every account
+func openNegativeCache() (*bl3.NegativeCache, error) {
+	return bl3.OpenNegativeCache(configPath("bad-codes.json"))
+}
+
+// openShiftCodeIndex opens the SHIFT codes seen in the code lists, shared by every account
+func openShiftCodeIndex() (*bl3.ShiftCodeIndex, error) {
+	return bl3.OpenShiftCodeIndex(configPath("shift-codes-seen.json"))
+}
+
+// migrateLegacyCaches moves the old <md5>-shift-codes.json and <md5>-vip-codes.json
+// files into the redemption history and renames them so it only happens once
+func migrateLegacyCaches(store bl3.RedemptionStore, config *bl3.Bl3Config, account string) error {
+	return migrateLegacyCachesIn(configdir.New("bl3-auto-vip", "bl3-auto-vip"), store, config, account)
+}
+
+func migrateLegacyCachesIn(configDirs configdir.ConfigDir, store bl3.RedemptionStore, config *bl3.Bl3Config, account string) error {
+	shiftFilename := account + "-shift-codes.json"
+	if folder := configDirs.QueryFolderContainsFile(shiftFilename); folder != nil {
+		data, err := folder.ReadFile(shiftFilename)
+		if err != nil {
+			return err
+		}
+		codes := bl3.ShiftCodeMap{}
+		if err := json.Unmarshal(data, &codes); err != nil {
+			return errors.New("invalid SHIFT code cache " + shiftFilename)
+		}
+		if err := bl3.MigrateShiftCodeMap(store, account, codes); err != nil {
+			return err
+		}
+		path := filepath.Join(folder.Path, shiftFilename)
+		if err := os.Rename(path, path+".migrated"); err != nil {
+			return err
+		}
+	}
+
+	vipFilename := account + "-vip-codes.json"
+	if folder := configDirs.QueryFolderContainsFile(vipFilename); folder != nil {
+		data, err := folder.ReadFile(vipFilename)
+		if err != nil {
+			return err
+		}
+		codes := config.NewVipCodeMap()
+		cached := bl3.VipCodeMap{}
+		if err := json.Unmarshal(data, &cached); err != nil {
+			return errors.New("invalid VIP code cache " + vipFilename)
+		}
+		for codeType, typeCodes := range cached {
+			for code := range typeCodes {
+				codes.Add(codeType, code)
+			}
+		}
+		if err := bl3.MigrateVipCodeMap(store, account, codes); err != nil {
+			return err
+		}
+		path := filepath.Join(folder.Path, vipFilename)
+		if err := os.Rename(path, path+".migrated"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	bl3 "github.com/matt1484/bl3_auto_vip"
+	"github.com/shibukawa/configdir"
+)
+
+func TestMigrateLegacyCaches(t *testing.T) {
+	dir := t.TempDir()
+	configDirs := configdir.New("bl3-auto-vip-test", "bl3-auto-vip-test")
+	configDirs.LocalPath = dir
+	account := "0123456789abcdef0123456789abcdef"
+	files := map[string]string{
+		account + "-shift-codes.json": `{"ABCDE-12345-FGHIJ-67890-KLMNO": ["steam", "psn"]}`,
+		// "unknown" is not a code type in the config and is dropped
+		account + "-vip-codes.json": `{"vault": {"abc123": {}}, "Diamond": {"DIA456": {}}, "unknown": {"zzz999": {}}}`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := bl3.OpenJsonlRedemptionStore(filepath.Join(dir, "redemptions.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &bl3.Bl3Config{}
+	config.Vip.CodeTypeUrlMap = map[string]string{"vault": "", "diamond": ""}
+
+	// a second run finds nothing left to migrate
+	for i := 0; i < 2; i++ {
+		if err := migrateLegacyCachesIn(configDirs, store, config, account); err != nil {
+			t.Fatalf("migrateLegacyCachesIn() run %d = %v", i+1, err)
+		}
+	}
+
+	records, err := store.Records(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0)
+	for _, record := range records {
+		if record.Result != bl3.ResultRedeemed || record.Source != bl3.SourceMigration {
+			t.Errorf("migrated record %+v is not a redeemed migration", record)
+		}
+		got = append(got, record.Kind+" "+record.Platform+" "+record.Code)
+	}
+	sort.Strings(got)
+	want := []string{
+		"shift psn ABCDE-12345-FGHIJ-67890-KLMNO",
+		"shift steam ABCDE-12345-FGHIJ-67890-KLMNO",
+		"vip diamond dia456",
+		"vip vault abc123",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("migrated records = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("migrated records = %q, want %q", got, want)
+			break
+		}
+	}
+
+	for name := range files {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was not renamed", name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name+".migrated")); err != nil {
+			t.Errorf("%s.migrated is missing: %v", name, err)
+		}
+	}
+}
+
+func TestMigrateLegacyCachesInvalid(t *testing.T) {
+	dir := t.TempDir()
+	configDirs := configdir.New("bl3-auto-vip-test", "bl3-auto-vip-test")
+	configDirs.LocalPath = dir
+	account := "fedcba9876543210fedcba9876543210"
+	name := account + "-shift-codes.json"
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := bl3.OpenJsonlRedemptionStore(filepath.Join(dir, "redemptions.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateLegacyCachesIn(configDirs, store, &bl3.Bl3Config{}, account); err == nil {
+		t.Errorf("migrateLegacyCachesIn() with an invalid cache = nil, want an error")
+	}
+	// kept so it can be fixed by hand
+	if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+		t.Errorf("invalid %s was renamed: %v", name, err)
+	}
+}
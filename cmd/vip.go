@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	bl3 "github.com/matt1484/bl3_auto_vip"
+)
+
+func vipUsage() {
+	fmt.Println("Usage: bl3-auto-vip vip <status|activities list> [options]")
+}
+
+func doVip(args []string) error {
+	if len(args) < 1 {
+		vipUsage()
+		return errors.New("missing vip command")
+	}
+
+	switch args[0] {
+	case "status":
+		return doVipStatus(args[1:])
+	case "activities":
+		if len(args) > 1 && args[1] == "list" {
+			return doVipActivitiesList(args[2:])
+		}
+	}
+	vipUsage()
+	return errors.New("unknown vip command '" + args[0] + "'")
+}
+
+func doVipStatus(args []string) error {
+	flags := flag.NewFlagSet("vip status", flag.ContinueOnError)
+	username, password := "", ""
+	addCredentialFlags(flags, &username, &password)
+	recent := flags.Int("recent", 10, "Number of recent activities to show")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	promptCredentials(&username, &password)
+	client, err := setupClient(username, password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print("Getting VIP status . . . . . ")
+	status, err := client.GetVipStatus(*recent)
+	if err != nil {
+		printError(err)
+		return err
+	}
+	fmt.Println("success!")
+
+	fmt.Println("Points          : " + strconv.Itoa(status.Points))
+	fmt.Println("Lifetime points : " + strconv.Itoa(status.LifetimePoints))
+	if status.Tier != "" {
+		fmt.Println("Tier            : " + status.Tier)
+	}
+	if len(status.Recent) == 0 {
+		fmt.Println("No recent activity.")
+		return nil
+	}
+
+	fmt.Println("Recent activity:")
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TIME\tPOINTS\tTITLE\tNOTES")
+	for _, activity := range status.Recent {
+		when := ""
+		if !activity.Time.IsZero() {
+			when = activity.Time.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintln(table, strings.Join([]string{when, strconv.Itoa(activity.Points), activity.Title, activity.Notes}, "\t"))
+	}
+	return table.Flush()
+}
+
+func addActivityRuleFlags(flags *flag.FlagSet) (*listFlag, *listFlag) {
+	includes, excludes := &listFlag{}, &listFlag{}
+	flags.Var(includes, "activity-include", "Only claim VIP activities matching field:pattern, field is title, link, name or any and pattern a glob or re:regex (repeatable)")
+	flags.Var(excludes, "activity-exclude", "Never claim VIP activities matching field:pattern (repeatable)")
+	return includes, excludes
+}
+
+// applyActivityRules adds the rules from flags after the configured ones
+func applyActivityRules(conf *bl3.VipConfig, includes, excludes []string) error {
+	for _, include := range includes {
+		rule, err := bl3.ParseVipActivityRule(bl3.RuleInclude, include)
+		if err != nil {
+			return err
+		}
+		conf.ActivityRules = append(conf.ActivityRules, rule)
+	}
+	for _, exclude := range excludes {
+		rule, err := bl3.ParseVipActivityRule(bl3.RuleExclude, exclude)
+		if err != nil {
+			return err
+		}
+		conf.ActivityRules = append(conf.ActivityRules, rule)
+	}
+	return nil
+}
+
+func doVipActivitiesList(args []string) error {
+	flags := flag.NewFlagSet("vip activities list", flag.ContinueOnError)
+	username, password := "", ""
+	addCredentialFlags(flags, &username, &password)
+	includes, excludes := addActivityRuleFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	promptCredentials(&username, &password)
+	client, err := setupClient(username, password)
+	if err != nil {
+		return err
+	}
+	if err := applyActivityRules(&client.Config.Vip, *includes, *excludes); err != nil {
+		return err
+	}
+
+	fmt.Print("Getting available VIP activities . . . . . ")
+	activities, err := client.GetVipActivities()
+	if err != nil {
+		printError(err)
+		return err
+	}
+	fmt.Println("success!")
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TITLE\tNAME\tLINK\tCLAIM\tRULE\tAVAILABLE")
+	for _, activity := range activities {
+		claim, rule := client.Config.Vip.ClaimsActivity(activity)
+		claimText := "no"
+		if claim {
+			claimText = "yes"
+		}
+		available := "now"
+		if activity.Capped {
+			available = activity.NextAvailable.Local().Format("Mon Jan 2 15:04 MST")
+		}
+		fmt.Fprintln(table, strings.Join([]string{activity.Title, activity.Name, activity.Link, claimText, rule, available}, "\t"))
+	}
+	return table.Flush()
+}
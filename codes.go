@@ -0,0 +1,201 @@
+package bl3_auto_vip
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// CodeCandidate is something in a piece of text that looks like a code
+type CodeCandidate struct {
+	Code string
+	Kind string
+	// Type is the VIP code type when it was written as type:code
+	Type string
+	// Confidence goes from 0 (a guess) to 1 (certainly a code)
+	Confidence float64
+}
+
+// MinCodeConfidence is the confidence below which a candidate is more likely noise than a code
+const MinCodeConfidence = 0.5
+
+var (
+	htmlTagPattern    = regexp.MustCompile(`(?s)<(script|style)[^>]*>.*?</(script|style)>|<[^>]+>`)
+	dashPattern       = regexp.MustCompile(`\s*[\x{2010}-\x{2015}\x{2212}\x{FE63}\x{FF0D}-]\s*`)
+	shiftCodePattern  = regexp.MustCompile(`(?i)\b[a-z0-9]{5}(?:-[a-z0-9]{5}){4}\b`)
+	bareShiftPattern  = regexp.MustCompile(`(?i)\b[a-z0-9]{25}\b`)
+	typedVipPattern   = regexp.MustCompile(`(?i)\b([a-z]+):([a-z0-9]{4,32})\b`)
+	vipLinePattern    = regexp.MustCompile(`(?i)^[a-z0-9]{4,32}$`)
+	vipTokenPattern   = regexp.MustCompile(`\b[A-Z0-9]{5,32}\b`)
+	vipKeywordPattern = regexp.MustCompile(`(?i)\b(?:vip|code|codes)\b\W*$`)
+)
+
+// stripHtml turns html into plain text, keeping line breaks between block elements
+func stripHtml(text string) string {
+	if !strings.Contains(text, "<") {
+		return html.UnescapeString(text)
+	}
+	text = htmlTagPattern.ReplaceAllStringFunc(text, func(tag string) string {
+		lower := strings.ToLower(tag)
+		for _, block := range []string{"<br", "<p", "</p", "<div", "</div", "<li", "<tr", "</tr", "<td", "<th"} {
+			if strings.HasPrefix(lower, block) {
+				return "\n"
+			}
+		}
+		return " "
+	})
+	return html.UnescapeString(text)
+}
+
+func hasLetterAndDigit(s string) bool {
+	return strings.IndexAny(s, "0123456789") >= 0 && strings.IndexFunc(s, func(r rune) bool {
+		return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+	}) >= 0
+}
+
+// NormalizeShiftCode upper cases a SHIFT code and puts the dashes in the right places,
+// returning "" when it is not a SHIFT code
+func NormalizeShiftCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(dashPattern.ReplaceAllString(code, "-")))
+	if shiftCodePattern.FindString(code) == code && code != "" {
+		return code
+	}
+	bare := strings.Replace(code, "-", "", -1)
+	if bareShiftPattern.FindString(bare) == bare && bare != "" {
+		return bare[0:5] + "-" + bare[5:10] + "-" + bare[10:15] + "-" + bare[15:20] + "-" + bare[20:25]
+	}
+	return ""
+}
+
+// ExtractCodes finds SHIFT and VIP codes in text or html, such as tweets or reddit comments.
+// Only the VIP code types in codeTypes are recognized in type:code.
+// Codes are normalized (SHIFT upper case with dashes, VIP lower case), de-duplicated keeping
+// the highest confidence, and sorted from most to least confident.
+func ExtractCodes(text string, codeTypes []string) []CodeCandidate {
+	found := make(map[string]*CodeCandidate)
+	order := make([]string, 0)
+	add := func(candidate CodeCandidate) {
+		key := candidate.Kind + ":" + candidate.Code
+		existing, ok := found[key]
+		if !ok {
+			found[key] = &candidate
+			order = append(order, key)
+			return
+		}
+		if candidate.Confidence > existing.Confidence {
+			existing.Confidence = candidate.Confidence
+		}
+		if existing.Type == "" {
+			existing.Type = candidate.Type
+		}
+	}
+
+	knownTypes := StringSet{}
+	for _, codeType := range codeTypes {
+		knownTypes.Add(strings.ToLower(codeType))
+	}
+	// things like "Edit:thanks" are not codes
+	isTypedCode := func(match []string) bool {
+		if _, found := knownTypes[strings.ToLower(match[1])]; !found {
+			return false
+		}
+		return hasLetterAndDigit(match[2]) || len(match[2]) >= 6
+	}
+
+	text = dashPattern.ReplaceAllString(stripHtml(text), "-")
+	for _, line := range strings.Split(text, "\n") {
+		lone := strings.Trim(strings.TrimSpace(line), "*`_\"'.,;|")
+		for _, match := range shiftCodePattern.FindAllString(line, -1) {
+			confidence := 0.95
+			if !hasLetterAndDigit(match) {
+				confidence = 0.7
+			}
+			add(CodeCandidate{Code: NormalizeShiftCode(match), Kind: KindShift, Confidence: confidence})
+		}
+		line = shiftCodePattern.ReplaceAllString(line, " ")
+
+		// people sometimes leave out the dashes
+		for _, match := range bareShiftPattern.FindAllString(line, -1) {
+			if hasLetterAndDigit(match) {
+				add(CodeCandidate{Code: NormalizeShiftCode(match), Kind: KindShift, Confidence: 0.6})
+			}
+		}
+		line = bareShiftPattern.ReplaceAllString(line, " ")
+
+		for _, match := range typedVipPattern.FindAllStringSubmatch(line, -1) {
+			if isTypedCode(match) {
+				add(CodeCandidate{Code: strings.ToLower(match[2]), Kind: KindVip, Type: strings.ToLower(match[1]), Confidence: 0.9})
+			}
+		}
+		line = typedVipPattern.ReplaceAllStringFunc(line, func(typed string) string {
+			if isTypedCode(typedVipPattern.FindStringSubmatch(typed)) {
+				return " "
+			}
+			return typed
+		})
+
+		// only when nothing else on the line was a code
+		rest := strings.Trim(strings.TrimSpace(line), "*`_\"'.,;|")
+		if rest == lone && vipLinePattern.MatchString(lone) {
+			// a code on its own line, words and numbers like "HELLO" or "2019" stay below MinCodeConfidence
+			confidence := 0.4
+			if hasLetterAndDigit(lone) {
+				confidence += 0.25
+				if strings.ToUpper(lone) == lone {
+					confidence += 0.1
+				}
+			}
+			add(CodeCandidate{Code: strings.ToLower(lone), Kind: KindVip, Confidence: confidence})
+			continue
+		}
+
+		// a code in a sentence has to stand out: upper case, and a digit or a "code" right before it
+		for _, loc := range vipTokenPattern.FindAllStringIndex(line, -1) {
+			token := line[loc[0]:loc[1]]
+			confidence := 0.0
+			if hasLetterAndDigit(token) {
+				confidence += 0.45
+			}
+			if vipKeywordPattern.MatchString(line[:loc[0]]) {
+				confidence += 0.5
+			}
+			if confidence > 1 {
+				confidence = 1
+			}
+			if confidence > 0 {
+				add(CodeCandidate{Code: strings.ToLower(token), Kind: KindVip, Confidence: confidence})
+			}
+		}
+	}
+
+	candidates := make([]CodeCandidate, 0, len(order))
+	for _, key := range order {
+		candidates = append(candidates, *found[key])
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+	return candidates
+}
+
+// ParseCodes extracts the likely SHIFT and VIP codes from text. VIP codes with a known type
+// are returned as type:code.
+func ParseCodes(text string, codeTypes []string) ([]string, []string) {
+	shiftCodes := make([]string, 0)
+	vipCodes := make([]string, 0)
+	for _, candidate := range ExtractCodes(text, codeTypes) {
+		if candidate.Confidence < MinCodeConfidence {
+			continue
+		}
+		switch {
+		case candidate.Kind == KindShift:
+			shiftCodes = append(shiftCodes, candidate.Code)
+		case candidate.Type != "":
+			vipCodes = append(vipCodes, candidate.Type+":"+candidate.Code)
+		default:
+			vipCodes = append(vipCodes, candidate.Code)
+		}
+	}
+	return shiftCodes, vipCodes
+}
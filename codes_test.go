@@ -0,0 +1,64 @@
+package bl3_auto_vip
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testCodeTypes = []string{"vault", "diamond", "creator", "email", "boss"}
+
+func TestNormalizeShiftCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"ABCDE-12345-FGHIJ-67890-KLMNO", "ABCDE-12345-FGHIJ-67890-KLMNO"},
+		{" abcde-12345-fghij-67890-klmno ", "ABCDE-12345-FGHIJ-67890-KLMNO"},
+		{"ABCDE12345FGHIJ67890KLMNO", "ABCDE-12345-FGHIJ-67890-KLMNO"},
+		{"ABCDE – 12345 — FGHIJ-67890-KLMNO", "ABCDE-12345-FGHIJ-67890-KLMNO"},
+		{"ABCDE-12345-FGHIJ-67890", ""},
+		{"ABCDE-12345-FGHIJ-67890-KLMNOP", ""},
+		{"ABCDE-12345-FGHIJ-67890-KLM!O", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := NormalizeShiftCode(test.code); got != test.want {
+			t.Errorf("NormalizeShiftCode(%q) = %q, want %q", test.code, got, test.want)
+		}
+	}
+}
+
+func TestExtractCodes(t *testing.T) {
+	tests := []struct {
+		text      string
+		wantShift []string
+		wantVip   []string
+	}{
+		{"ABCDE-12345-FGHIJ-67890-KLMNO", []string{"ABCDE-12345-FGHIJ-67890-KLMNO"}, []string{}},
+		{"New code for everyone: abcde12345fghij67890klmno!", []string{"ABCDE-12345-FGHIJ-67890-KLMNO"}, []string{}},
+		{"<p>vault:abc123</p><p>Email:NEWS2019</p>", []string{}, []string{"vault:abc123", "email:news2019"}},
+		{"ABC123\n  diamond99  ", []string{}, []string{"abc123", "diamond99"}},
+		{"Todays VIP code: BORDERLANDS", []string{}, []string{"borderlands"}},
+		{"Try PANDORA42 before it expires", []string{}, []string{}},
+		// noise on its own line
+		{"2019\nXBOX\nHELLO\nthanks", []string{}, []string{}},
+		// only configured code types are read as type:code
+		{"Edit:thanks\nUpdate:tomorrow\nsomething:abc123", []string{}, []string{}},
+		{"https://example.com/abc123 at 10:30", []string{}, []string{}},
+	}
+	for _, test := range tests {
+		shiftCodes, vipCodes := ParseCodes(test.text, testCodeTypes)
+		if !reflect.DeepEqual(shiftCodes, test.wantShift) || !reflect.DeepEqual(vipCodes, test.wantVip) {
+			t.Errorf("ParseCodes(%q) = %v, %v, want %v, %v", test.text, shiftCodes, vipCodes, test.wantShift, test.wantVip)
+		}
+	}
+
+	candidates := ExtractCodes("vault:abc123\nHELLO", testCodeTypes)
+	want := []CodeCandidate{
+		{Code: "abc123", Kind: KindVip, Type: "vault", Confidence: 0.9},
+		{Code: "hello", Kind: KindVip, Confidence: 0.4},
+	}
+	if !reflect.DeepEqual(candidates, want) {
+		t.Errorf("ExtractCodes() = %+v, want %+v", candidates, want)
+	}
+}
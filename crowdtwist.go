@@ -0,0 +1,33 @@
+package bl3_auto_vip
+
+import (
+	"errors"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/matt1484/bl3_auto_vip/crowdtwist"
+)
+
+// crowdtwistTransport sends crowdtwist requests with the client's session, metrics and strict decoding
+type crowdtwistTransport struct {
+	client *HttpClient
+}
+
+func (transport crowdtwistTransport) PostJson(url string, data interface{}, out interface{}) error {
+	response, err := transport.client.PostJson(url, data)
+	if err != nil {
+		return errors.New("failed to reach crowdtwist")
+	}
+	return response.DecodeJson(out)
+}
+
+func (transport crowdtwistTransport) GetHtml(url string) (*goquery.Document, error) {
+	response, err := transport.client.Get(url)
+	if err != nil {
+		return nil, errors.New("failed to reach crowdtwist")
+	}
+	return response.BodyAsHtmlDoc()
+}
+
+func (client *Bl3Client) crowdtwist() *crowdtwist.Client {
+	return crowdtwist.NewClient(crowdtwistTransport{client: &client.HttpClient})
+}
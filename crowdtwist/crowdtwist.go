@@ -0,0 +1,125 @@
+// Package crowdtwist talks to the crowdtwist widgets behind the Borderlands VIP program.
+// It builds the requests and describes the responses, sending them is left to a Transport.
+package crowdtwist
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const DefaultBaseUrl = "https://2kgames.crowdtwist.com"
+
+// Widgets used by the VIP program
+const (
+	WidgetActivityList = 9446
+	WidgetCodeTypes    = 9904
+	WidgetUser         = 9470
+)
+
+// Transport sends requests, with the session of a logged in account
+type Transport interface {
+	// PostJson posts data as JSON and decodes the response into out
+	PostJson(url string, data interface{}, out interface{}) error
+	GetHtml(url string) (*goquery.Document, error)
+}
+
+type Client struct {
+	Transport Transport
+	BaseUrl   string
+}
+
+func NewClient(transport Transport) *Client {
+	return &Client{
+		Transport: transport,
+		BaseUrl:   DefaultBaseUrl,
+	}
+}
+
+// Post sends the request to a widget
+func (client *Client) Post(widgetId int, request *Request) (*Response, error) {
+	response := &Response{request: request}
+	url := client.BaseUrl + "/request?widgetId=" + strconv.Itoa(widgetId)
+	if err := client.Transport.PostJson(url, request, response); err != nil {
+		return response, err
+	}
+	return response, nil
+}
+
+// EachUserActivity calls each with the account's activities from newest to oldest, getting them
+// pageSize at a time, until there are no more or each returns false
+func (client *Client) EachUserActivity(widgetId, pageSize int, each func(UserActivity) bool) error {
+	if pageSize < 1 {
+		return errors.New("invalid page size " + strconv.Itoa(pageSize))
+	}
+	for rowStart := 1; ; rowStart += pageSize {
+		response, err := client.Post(widgetId, NewRequest().UserActivitiesMe(rowStart, rowStart+pageSize-1))
+		if err != nil {
+			return err
+		}
+		page := response.ModelData.Activity.NewestActivities
+		for _, activity := range page {
+			if !each(activity) {
+				return nil
+			}
+		}
+		if len(page) < pageSize {
+			return nil
+		}
+	}
+}
+
+// RedeemCode redeems a code at the url of a code redemption campaign
+func (client *Client) RedeemCode(campaignUrl, code string) (CodeRedemption, error) {
+	redemption := CodeRedemption{}
+	err := client.Transport.PostJson(campaignUrl, map[string]string{"code": code}, &redemption)
+	return redemption, err
+}
+
+// CampaignUrl is where codes for a code redemption campaign are redeemed
+func (client *Client) CampaignUrl(campaignId int) string {
+	return client.BaseUrl + "/code-redemption-campaign/redeem?cid=" + strconv.Itoa(campaignId)
+}
+
+// WidgetConf reads the widgetConf object of a widget page into out
+func (client *Client) WidgetConf(path string, out interface{}) error {
+	widgetHtml, err := client.Transport.GetHtml(client.BaseUrl + path)
+	if err != nil {
+		return err
+	}
+
+	err = errors.New("no script with a widgetConf")
+	widgetConf := ""
+	widgetHtml.Find("script").EachWithBreak(func(i int, scriptTag *goquery.Selection) bool {
+		script := scriptTag.Text()
+		if !strings.Contains(script, "widgetConf") {
+			return true
+		}
+		widgetConf, err = ExtractJsObject(script, "widgetConf")
+		return err != nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(widgetConf), out); err != nil {
+		return errors.New("unexpected widgetConf: " + err.Error())
+	}
+	return nil
+}
+
+// ActivityList gets the config of an activity list widget
+func (client *Client) ActivityList(widgetId int) (ActivityListConf, error) {
+	conf := ActivityListConf{}
+	err := client.WidgetConf("/widgets/t/activity-list/"+strconv.Itoa(widgetId)+"?__locale__=en", &conf)
+	return conf, err
+}
+
+// CodeRedemption gets the config of a code redemption widget
+func (client *Client) CodeRedemption(widgetId int) (CodeRedemptionConf, error) {
+	conf := CodeRedemptionConf{}
+	err := client.WidgetConf("/widgets/t/code-redemption/"+strconv.Itoa(widgetId), &conf)
+	return conf, err
+}
@@ -0,0 +1,286 @@
+package crowdtwist
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var jsonNumberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// ExtractJsObject finds the object or array assigned to name in a script (name = {...} or
+// name: [...]) and returns it as JSON. The literal can use what JavaScript allows and JSON does
+// not: single quoted strings, unquoted keys, comments, trailing commas and undefined.
+func ExtractJsObject(script, name string) (string, error) {
+	if name == "" {
+		return "", errors.New("missing name")
+	}
+	err := errors.New("'" + name + "' not found")
+	for pos := 0; pos < len(script); {
+		i := strings.Index(script[pos:], name)
+		if i < 0 {
+			break
+		}
+		start := pos + i
+		end := start + len(name)
+		pos = end
+		if start > 0 && isJsIdentifierByte(script[start-1]) || end < len(script) && isJsIdentifierByte(script[end]) {
+			continue
+		}
+
+		j := skipJsSpace(script, end)
+		if j >= len(script) || script[j] != '=' && script[j] != ':' || strings.HasPrefix(script[j:], "==") {
+			continue
+		}
+		j = skipJsSpace(script, j+1)
+		if j >= len(script) || script[j] != '{' && script[j] != '[' {
+			err = errors.New("'" + name + "' is not an object or array")
+			continue
+		}
+
+		converted, convertErr := jsLiteralToJson(script[j:])
+		if convertErr != nil {
+			err = errors.New("bad '" + name + "': " + convertErr.Error())
+			continue
+		}
+		return converted, nil
+	}
+	return "", err
+}
+
+func isJsIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= utf8.RuneSelf
+}
+
+// skipJsSpace skips white space and comments
+func skipJsSpace(s string, i int) int {
+	for i < len(s) {
+		switch {
+		case s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r':
+			i++
+		case strings.HasPrefix(s[i:], "//"):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				return len(s)
+			}
+			i += end + 1
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return len(s)
+			}
+			i += end + 4
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// jsLiteralToJson converts the object or array at the start of s, ignoring whatever follows it
+func jsLiteralToJson(s string) (string, error) {
+	out := strings.Builder{}
+	stack := make([]byte, 0)
+	expectKey := false
+	// commas are held back until something follows them, which drops trailing commas
+	comma := false
+	// afterValue is set when a value ended and only a comma or closing bracket can follow
+	afterValue := false
+	missingComma := func(i int) error {
+		return errors.New("missing ',' at " + strconv.Itoa(i))
+	}
+	write := func(s string) {
+		if comma {
+			out.WriteByte(',')
+			comma = false
+		}
+		out.WriteString(s)
+	}
+
+	for i := 0; i < len(s); {
+		i = skipJsSpace(s, i)
+		if i >= len(s) {
+			break
+		}
+		c := s[i]
+		switch {
+		case c == '{' || c == '[':
+			if afterValue {
+				return "", missingComma(i)
+			}
+			afterValue = false
+			stack = append(stack, c)
+			expectKey = c == '{'
+			write(string(c))
+			i++
+		case c == '}' || c == ']':
+			if len(stack) == 0 || c == '}' && stack[len(stack)-1] != '{' || c == ']' && stack[len(stack)-1] != '[' {
+				return "", errors.New("unbalanced '" + string(c) + "' at " + strconv.Itoa(i))
+			}
+			stack = stack[:len(stack)-1]
+			comma = false
+			out.WriteByte(c)
+			i++
+			if len(stack) == 0 {
+				if !json.Valid([]byte(out.String())) {
+					return "", errors.New("not valid after conversion")
+				}
+				return out.String(), nil
+			}
+			expectKey = false
+			afterValue = true
+		case c == ',':
+			if comma {
+				return "", errors.New("unexpected ',' at " + strconv.Itoa(i))
+			}
+			comma = true
+			afterValue = false
+			expectKey = len(stack) > 0 && stack[len(stack)-1] == '{'
+			i++
+		case c == ':':
+			write(":")
+			afterValue = false
+			i++
+		case c == '"' || c == '\'' || c == '`':
+			if afterValue {
+				return "", missingComma(i)
+			}
+			value, end, err := readJsString(s, i)
+			if err != nil {
+				return "", err
+			}
+			write(jsonString(value))
+			afterValue = !expectKey
+			expectKey = false
+			i = end
+		case c == '-' || c == '+' || c == '.' || c >= '0' && c <= '9':
+			if afterValue {
+				return "", missingComma(i)
+			}
+			end := i + 1
+			for end < len(s) && (isJsIdentifierByte(s[end]) || s[end] == '.' || (s[end] == '+' || s[end] == '-') && (s[end-1] == 'e' || s[end-1] == 'E')) {
+				end++
+			}
+			number, err := jsNumberToJson(s[i:end])
+			if err != nil {
+				return "", err
+			}
+			if expectKey {
+				number = jsonString(number)
+			}
+			write(number)
+			afterValue = !expectKey
+			expectKey = false
+			i = end
+		case isJsIdentifierByte(c):
+			if afterValue {
+				return "", missingComma(i)
+			}
+			end := i
+			for end < len(s) && isJsIdentifierByte(s[end]) {
+				end++
+			}
+			identifier := s[i:end]
+			i = end
+			afterValue = !expectKey
+			if expectKey {
+				write(jsonString(identifier))
+				expectKey = false
+				continue
+			}
+			switch identifier {
+			case "true", "false", "null":
+				write(identifier)
+			case "undefined":
+				write("null")
+			default:
+				return "", errors.New("unsupported value '" + identifier + "'")
+			}
+		default:
+			return "", errors.New("unexpected '" + string(c) + "' at " + strconv.Itoa(i))
+		}
+	}
+	return "", errors.New("unterminated object")
+}
+
+// readJsString decodes the string starting at s[i] and returns where it ends
+func readJsString(s string, i int) (string, int, error) {
+	quote := s[i]
+	value := strings.Builder{}
+	for j := i + 1; j < len(s); j++ {
+		c := s[j]
+		switch {
+		case c == quote:
+			return value.String(), j + 1, nil
+		case c == '\n' && quote != '`':
+			return "", 0, errors.New("unterminated string at " + strconv.Itoa(i))
+		case c != '\\':
+			value.WriteByte(c)
+			continue
+		}
+
+		j++
+		if j >= len(s) {
+			break
+		}
+		switch s[j] {
+		case 'n':
+			value.WriteByte('\n')
+		case 't':
+			value.WriteByte('\t')
+		case 'r':
+			value.WriteByte('\r')
+		case 'b':
+			value.WriteByte('\b')
+		case 'f':
+			value.WriteByte('\f')
+		case 'v':
+			value.WriteByte('\v')
+		case '0':
+			value.WriteByte(0)
+		case '\n':
+			// line continuation
+		case 'x', 'u':
+			size := 2
+			if s[j] == 'u' {
+				size = 4
+			}
+			if j+size >= len(s) {
+				return "", 0, errors.New("bad escape at " + strconv.Itoa(j))
+			}
+			code, err := strconv.ParseUint(s[j+1:j+1+size], 16, 32)
+			if err != nil {
+				return "", 0, errors.New("bad escape at " + strconv.Itoa(j))
+			}
+			value.WriteRune(rune(code))
+			j += size
+		default:
+			value.WriteByte(s[j])
+		}
+	}
+	return "", 0, errors.New("unterminated string at " + strconv.Itoa(i))
+}
+
+func jsonString(s string) string {
+	// strings are not always valid utf8, Marshal replaces what is not
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// jsNumberToJson rewrites numbers JSON does not allow, like 0x1f, .5 or +1
+func jsNumberToJson(number string) (string, error) {
+	if jsonNumberPattern.MatchString(number) {
+		return number, nil
+	}
+	if i, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return strconv.FormatInt(i, 10), nil
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil || f != f || f > 1e308 || f < -1e308 {
+		return "", errors.New("bad number '" + number + "'")
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64), nil
+}
@@ -0,0 +1,62 @@
+package crowdtwist
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestExtractJsObject(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{`var widgetConf = {"entries": [{"id": 1}]};`, `{"entries":[{"id":1}]}`},
+		{`widgetConf={a:'x;y=z',b:[1,2,],}; other = 1;`, `{"a":"x;y=z","b":[1,2]}`},
+		{"window.widgetConf = {\n  // comment }\n  url: \"a=b\", /* } */ n: 0x10, e: undefined\n};", `{"url":"a=b","n":16,"e":null}`},
+		{`x.widgetConfig = {}; widgetConf == 1; var widgetConf = ["A", 'it\'s']`, `["A","it's"]`},
+		{`ct.init({widgetConf: {campaignId: 5264}})`, `{"campaignId":5264}`},
+	}
+	for _, test := range tests {
+		got, err := ExtractJsObject(test.script, "widgetConf")
+		if err != nil {
+			t.Errorf("ExtractJsObject(%q) failed: %v", test.script, err)
+			continue
+		}
+		var gotValue, wantValue interface{}
+		json.Unmarshal([]byte(got), &gotValue)
+		json.Unmarshal([]byte(test.want), &wantValue)
+		if !reflect.DeepEqual(gotValue, wantValue) {
+			t.Errorf("ExtractJsObject(%q) = %s, want %s", test.script, got, test.want)
+		}
+	}
+
+	for _, script := range []string{
+		``,
+		`var other = {};`,
+		`widgetConf = {a: 1`,
+		`widgetConf = {a: 1 b: 2}`,
+		`widgetConf = {a: foo()}`,
+		`widgetConf = "{}"`,
+		`widgetConf = [1, 2}`,
+	} {
+		if got, err := ExtractJsObject(script, "widgetConf"); err == nil {
+			t.Errorf("ExtractJsObject(%q) = %s, want an error", script, got)
+		}
+	}
+}
+
+func FuzzExtractJsObject(f *testing.F) {
+	f.Add(`var widgetConf = {"entries": [{"activity": {"name": "a"}}]};`)
+	f.Add(`widgetConf={a:'x;y=z',b:[1,2,],};`)
+	f.Add("widgetConf = {/* } */ s: `multi\nline`, n: -.5e3, h: 0xff, u: undefined}")
+	f.Add(`widgetConf = ["\x41B\
+", 'it\'s', {}]`)
+	f.Add(`widgetConf = {a: 1`)
+	f.Fuzz(func(t *testing.T, script string) {
+		got, err := ExtractJsObject(script, "widgetConf")
+		if err == nil && !json.Valid([]byte(got)) {
+			t.Errorf("ExtractJsObject(%q) = %q, which is not valid json", script, got)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package crowdtwist
+
+// The response fields tagged required are checked by transports that support it, see ShapeChecker
+// in the parent package. They are the properties the request builders ask for.
+
+type query struct {
+	Type string                 `json:"type"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type modelQuery struct {
+	Properties []string `json:"properties"`
+	Query      query    `json:"query"`
+}
+
+// Request is a request to a widget, build it with NewRequest and the query methods
+type Request struct {
+	ModelData map[string]map[string]modelQuery `json:"model_data"`
+}
+
+func NewRequest() *Request {
+	return &Request{ModelData: make(map[string]map[string]modelQuery)}
+}
+
+func (request *Request) add(model, name string, q modelQuery) *Request {
+	if _, found := request.ModelData[model]; !found {
+		request.ModelData[model] = make(map[string]modelQuery)
+	}
+	request.ModelData[model][name] = q
+	return request
+}
+
+// Me asks for the account's points and tier
+func (request *Request) Me() *Request {
+	return request.add("user", "me", modelQuery{
+		Properties: []string{"points", "lifetime_points", "tier_name"},
+		Query:      query{Type: "me"},
+	})
+}
+
+// UserActivitiesMe asks for the account's activities from rowStart to rowEnd, newest first and counting from 1
+func (request *Request) UserActivitiesMe(rowStart, rowEnd int) *Request {
+	return request.add("activity", "newest_activities", modelQuery{
+		Properties: []string{"title", "notes", "points", "date_created"},
+		Query: query{
+			Type: "user_activities_me",
+			Args: map[string]interface{}{
+				"row_start": rowStart,
+				"row_end":   rowEnd,
+			},
+		},
+	})
+}
+
+// ActivitiesByName asks for activities and whether the account can still claim them
+func (request *Request) ActivitiesByName(names []string) *Request {
+	return request.add("activity", "activities", modelQuery{
+		Properties: []string{"title", "link_href", "name", "user_activity_status"},
+		Query: query{
+			Type: "activities_by_name",
+			Args: map[string]interface{}{
+				"names": names,
+			},
+		},
+	})
+}
+
+type User struct {
+	Points         int    `json:"points" required:"true"`
+	LifetimePoints int    `json:"lifetime_points"`
+	TierName       string `json:"tier_name"`
+}
+
+// UserActivity is something the account did, Notes holds the code of code redemptions
+type UserActivity struct {
+	Title       string `json:"title" required:"true"`
+	Notes       string `json:"notes"`
+	Points      int    `json:"points"`
+	DateCreated string `json:"date_created"`
+}
+
+// ActivityStatus is whether the account can claim an activity. The cap fields are empty for
+// activities without a frequency cap.
+type ActivityStatus struct {
+	HasReachedFreqCap bool `json:"has_reached_freq_cap" required:"true"`
+	// FreqCapInterval is how often the cap resets, like "day" or "week"
+	FreqCapInterval string `json:"freq_cap_interval"`
+	// NextAvailableDate is when a capped activity can be claimed again
+	NextAvailableDate string `json:"next_available_date"`
+}
+
+type Activity struct {
+	Title              string         `json:"title" required:"true"`
+	LinkHref           string         `json:"link_href" required:"true"`
+	Name               string         `json:"name" required:"true"`
+	UserActivityStatus ActivityStatus `json:"user_activity_status" required:"true"`
+}
+
+type Response struct {
+	ModelData struct {
+		User struct {
+			Me *User `json:"me"`
+		} `json:"user"`
+		Activity struct {
+			NewestActivities []UserActivity `json:"newest_activities"`
+			Activities       []Activity     `json:"activities"`
+		} `json:"activity"`
+	} `json:"model_data" required:"true"`
+	request *Request
+}
+
+// CheckShape checks that everything the request asked for came back
+func (response *Response) CheckShape() string {
+	if response.request == nil {
+		return ""
+	}
+	for model, queries := range response.request.ModelData {
+		for name := range queries {
+			missing := false
+			switch model + "." + name {
+			case "user.me":
+				missing = response.ModelData.User.Me == nil
+			case "activity.newest_activities":
+				missing = response.ModelData.Activity.NewestActivities == nil
+			case "activity.activities":
+				missing = response.ModelData.Activity.Activities == nil
+			}
+			if missing {
+				return "missing 'model_data." + model + "." + name + "'"
+			}
+		}
+	}
+	return ""
+}
+
+// CodeRedemption either has a message when the code was accepted or an exception when not
+type CodeRedemption struct {
+	Message   string `json:"message"`
+	Exception *struct {
+		Model string `json:"model" required:"true"`
+	} `json:"exception"`
+}
+
+func (redemption *CodeRedemption) CheckShape() string {
+	if redemption.Message == "" && redemption.Exception == nil {
+		return "missing 'message' and 'exception'"
+	}
+	return ""
+}
+
+type ActivityListConf struct {
+	Entries []struct {
+		Activity struct {
+			Name string `json:"name"`
+		} `json:"activity"`
+		Link struct {
+			WidgetId   int    `json:"widgetId"`
+			WidgetName string `json:"widgetName"`
+		} `json:"link"`
+	} `json:"entries"`
+}
+
+type CodeRedemptionConf struct {
+	CampaignId int `json:"campaignId"`
+}
@@ -1,10 +1,14 @@
 module github.com/matt1484/bl3_auto_vip
 
-go 1.12
+go 1.18
 
 require (
 	github.com/PuerkitoBio/goquery v1.5.0
 	github.com/shibukawa/configdir v0.0.0-20170330084843-e180dbdc8da0
 	github.com/thedevsaddam/gojsonq v2.2.2+incompatible
+)
+
+require (
+	github.com/andybalholm/cascadia v1.0.0 // indirect
 	golang.org/x/net v0.0.0-20190909003024-a7b16738d86b // indirect
 )
@@ -0,0 +1,202 @@
+package bl3_auto_vip
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricVec is a counter or gauge with labels
+type metricVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newMetricVec(kind, name, help string, labels ...string) *metricVec {
+	m := &metricVec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+	// without labels there is a single series, report it from the start
+	if len(labels) == 0 {
+		m.values[""] = 0
+	}
+	return m
+}
+
+func (m *metricVec) Add(v float64, labelValues ...string) {
+	key := m.key(labelValues)
+	m.mu.Lock()
+	m.values[key] += v
+	m.mu.Unlock()
+}
+
+func (m *metricVec) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+func (m *metricVec) Set(v float64, labelValues ...string) {
+	key := m.key(labelValues)
+	m.mu.Lock()
+	m.values[key] = v
+	m.mu.Unlock()
+}
+
+// the exposition format only escapes these, unlike Go quoting which would also escape unicode
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (m *metricVec) key(labelValues []string) string {
+	pairs := make([]string, len(m.labels))
+	for i, label := range m.labels {
+		value := ""
+		if i < len(labelValues) {
+			value = labelValues[i]
+		}
+		pairs[i] = label + "=\"" + labelValueEscaper.Replace(value) + "\""
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	for _, key := range sortedKeys(m.values) {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, braces(key), formatFloat(m.values[key]))
+	}
+}
+
+// histogramVec tracks observations in cumulative buckets with labels
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	counts  map[string][]uint64
+	sums    map[string]float64
+	totals  map[string]uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+		totals:  make(map[string]uint64),
+	}
+}
+
+func (h *histogramVec) Observe(v float64, labelValues ...string) {
+	key := (&metricVec{labels: h.labels}).key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, found := h.counts[key]; !found {
+		h.counts[key] = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[key][i]++
+		}
+	}
+	h.sums[key] += v
+	h.totals[key]++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.sums) {
+		prefix := key
+		if prefix != "" {
+			prefix += ","
+		}
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", h.name, prefix, formatFloat(bound), h.counts[key][i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", h.name, prefix, h.totals[key])
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, braces(key), formatFloat(h.sums[key]))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, braces(key), h.totals[key])
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	MetricCodesDiscovered = newMetricVec("counter", "bl3_codes_discovered_total",
+		"Codes found in code lists.", "source")
+	MetricRedemptionAttempts = newMetricVec("counter", "bl3_redemption_attempts_total",
+		"Code redemption attempts.", "kind", "platform", "result")
+	MetricHttpResponses = newMetricVec("counter", "bl3_http_responses_total",
+		"HTTP responses by endpoint and status code, status is \"error\" when no response was received.", "endpoint", "status")
+	MetricHttpDuration = newHistogramVec("bl3_http_request_duration_seconds",
+		"HTTP request latency by endpoint.", []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "endpoint")
+	MetricLoginFailures = newMetricVec("counter", "bl3_login_failures_total",
+		"Failed logins.")
+	MetricLastSuccessfulRun = newMetricVec("gauge", "bl3_last_successful_run_timestamp_seconds",
+		"Unix time of the last run where every stage succeeded.", "account")
+)
+
+// WriteMetrics writes every metric in the Prometheus text format
+func WriteMetrics(w io.Writer) {
+	MetricCodesDiscovered.write(w)
+	MetricRedemptionAttempts.write(w)
+	MetricHttpResponses.write(w)
+	MetricHttpDuration.write(w)
+	MetricLoginFailures.write(w)
+	MetricLastSuccessfulRun.write(w)
+}
+
+// MetricsHandler serves the metrics for Prometheus to scrape
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w)
+	})
+}
+
+func observeHttp(endpoint string, start time.Time, res *http.Response, err error) {
+	MetricHttpDuration.Observe(time.Since(start).Seconds(), endpoint)
+	status := "error"
+	if err == nil && res != nil {
+		status = strconv.Itoa(res.StatusCode)
+	}
+	MetricHttpResponses.Inc(endpoint, status)
+}
@@ -0,0 +1,31 @@
+package bl3_auto_vip
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMetricLabelValues(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"vault", `test_total{kind="vault"} 1`},
+		{`say "hi"`, `test_total{kind="say \"hi\""} 1`},
+		{`C:\codes`, `test_total{kind="C:\\codes"} 1`},
+		{"two\nlines", `test_total{kind="two\nlines"} 1`},
+		{"tab\there", "test_total{kind=\"tab\there\"} 1"},
+		{"café – ✓", `test_total{kind="café – ✓"} 1`},
+	}
+	for _, test := range tests {
+		m := newMetricVec("counter", "test_total", "Test.", "kind")
+		m.Inc(test.value)
+		out := bytes.Buffer{}
+		m.write(&out)
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if got := lines[len(lines)-1]; got != test.want {
+			t.Errorf("Inc(%q) wrote %s, want %s", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package bl3_auto_vip
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a code that did not work is skipped before it is checked again
+const (
+	NegativeTtlInvalid     = 30 * 24 * time.Hour
+	NegativeTtlNoPlatforms = 7 * 24 * time.Hour
+)
+
+// NegativeEntry is a code that was checked and can not be redeemed
+type NegativeEntry struct {
+	Code    string    `json:"code"`
+	Kind    string    `json:"kind"`
+	Reason  string    `json:"reason"`
+	Checked time.Time `json:"checked"`
+	Expires time.Time `json:"expires"`
+	// Scope is what else the answer depended on, such as the enabled games or the VIP code type
+	Scope string `json:"scope,omitempty"`
+}
+
+// NegativeCache remembers codes that can not be redeemed so they are not checked on every run.
+// Every change is written to the file straight away.
+type NegativeCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]NegativeEntry
+	// Recheck ignores the entries, while still updating them with new answers
+	Recheck bool
+}
+
+func OpenNegativeCache(path string) (*NegativeCache, error) {
+	cache := &NegativeCache{path: path, entries: make(map[string]NegativeEntry)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, errors.New("Failed to open code cache")
+	}
+	entries := make([]NegativeEntry, 0)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		// it is only a cache, starting over is fine
+		return cache, nil
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.Expires.After(now) {
+			cache.entries[negativeKey(entry.Kind, entry.Scope, entry.Code)] = entry
+		}
+	}
+	return cache, nil
+}
+
+func negativeKey(kind, scope, code string) string {
+	return kind + ":" + scope + ":" + strings.ToLower(code)
+}
+
+// Get returns the entry of a code while it is fresh
+func (cache *NegativeCache) Get(kind, scope, code string) (NegativeEntry, bool) {
+	if cache == nil || cache.Recheck {
+		return NegativeEntry{}, false
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, found := cache.entries[negativeKey(kind, scope, code)]
+	if !found || !entry.Expires.After(time.Now()) {
+		return NegativeEntry{}, false
+	}
+	return entry, true
+}
+
+func (cache *NegativeCache) Add(kind, scope, code, reason string, ttl time.Duration) error {
+	if cache == nil {
+		return nil
+	}
+	now := time.Now()
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.entries[negativeKey(kind, scope, code)] = NegativeEntry{
+		Code:    code,
+		Kind:    kind,
+		Reason:  reason,
+		Checked: now,
+		Expires: now.Add(ttl),
+		Scope:   scope,
+	}
+	return cache.save()
+}
+
+// Remove forgets a code, for when it turned out to work after all
+func (cache *NegativeCache) Remove(kind, scope, code string) error {
+	if cache == nil {
+		return nil
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	key := negativeKey(kind, scope, code)
+	if _, found := cache.entries[key]; !found {
+		return nil
+	}
+	delete(cache.entries, key)
+	return cache.save()
+}
+
+// save expects the lock to be held
+func (cache *NegativeCache) save() error {
+	entries := make([]NegativeEntry, 0, len(cache.entries))
+	for _, entry := range cache.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return negativeKey(entries[i].Kind, entries[i].Scope, entries[i].Code) < negativeKey(entries[j].Kind, entries[j].Scope, entries[j].Code)
+	})
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cache.path), 0755); err != nil {
+		return errors.New("Failed to write code cache")
+	}
+	tmpPath := cache.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return errors.New("Failed to write code cache")
+	}
+	if err := os.Rename(tmpPath, cache.path); err != nil {
+		return errors.New("Failed to write code cache")
+	}
+	return nil
+}
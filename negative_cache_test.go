@@ -0,0 +1,79 @@
+package bl3_auto_vip
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNegativeCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad-codes.json")
+	cache, err := OpenNegativeCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adds := []struct {
+		kind, scope, code string
+		ttl               time.Duration
+	}{
+		{KindShift, "oak", "ABCDE-12345-FGHIJ-67890-KLMNO", NegativeTtlInvalid},
+		{KindShift, "oak", "BBBBB-12345-FGHIJ-67890-KLMNO", -time.Minute},
+		{KindVip, "vault", "abc123", NegativeTtlNoPlatforms},
+	}
+	for _, add := range adds {
+		if err := cache.Add(add.kind, add.scope, add.code, "invalid", add.ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		kind, scope, code string
+		want              bool
+	}{
+		{KindShift, "oak", "ABCDE-12345-FGHIJ-67890-KLMNO", true},
+		{KindShift, "oak", "abcde-12345-fghij-67890-klmno", true},
+		{KindShift, "oak,willow2", "ABCDE-12345-FGHIJ-67890-KLMNO", false},
+		{KindVip, "oak", "ABCDE-12345-FGHIJ-67890-KLMNO", false},
+		{KindShift, "oak", "BBBBB-12345-FGHIJ-67890-KLMNO", false},
+		{KindVip, "vault", "ABC123", true},
+		{KindVip, "diamond", "abc123", false},
+	}
+	check := func(name string, cache *NegativeCache) {
+		for _, test := range tests {
+			entry, found := cache.Get(test.kind, test.scope, test.code)
+			if found != test.want {
+				t.Errorf("%s: Get(%q, %q, %q) = %v, want %v", name, test.kind, test.scope, test.code, found, test.want)
+			}
+			if found && (entry.Reason != "invalid" || !entry.Expires.After(time.Now())) {
+				t.Errorf("%s: Get(%q, %q, %q) = %+v", name, test.kind, test.scope, test.code, entry)
+			}
+		}
+	}
+	check("added", cache)
+	reopened, err := OpenNegativeCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("reopened", reopened)
+
+	reopened.Recheck = true
+	if _, found := reopened.Get(KindVip, "vault", "abc123"); found {
+		t.Errorf("Get() with Recheck found an entry")
+	}
+	// answers found while rechecking still replace the old ones
+	if err := reopened.Remove(KindVip, "vault", "abc123"); err != nil {
+		t.Fatal(err)
+	}
+	reopened.Recheck = false
+	if _, found := reopened.Get(KindVip, "vault", "abc123"); found {
+		t.Errorf("Get() found a removed entry")
+	}
+	if _, found := reopened.Get(KindShift, "oak", "ABCDE-12345-FGHIJ-67890-KLMNO"); !found {
+		t.Errorf("Get() lost an entry after Recheck")
+	}
+
+	var none *NegativeCache
+	if _, found := none.Get(KindVip, "vault", "abc123"); found || none.Add(KindVip, "vault", "abc123", "", time.Hour) != nil {
+		t.Errorf("a nil NegativeCache is not empty")
+	}
+}
@@ -0,0 +1,186 @@
+package bl3_auto_vip
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrUnexpectedResponseShape is returned when a response does not have the fields we rely on,
+// which usually means 2K changed the API. Payload is the response body as received.
+type ErrUnexpectedResponseShape struct {
+	Url     string
+	Reason  string
+	Payload []byte
+}
+
+func (err *ErrUnexpectedResponseShape) Error() string {
+	return "unexpected response shape from " + err.Url + ": " + err.Reason
+}
+
+// IsUnexpectedResponseShape reports whether err is an ErrUnexpectedResponseShape
+func IsUnexpectedResponseShape(err error) bool {
+	_, ok := err.(*ErrUnexpectedResponseShape)
+	return ok
+}
+
+// ErrHttpStatus is returned for responses whose status says the body is not an answer to the
+// request, such as a server error or an expired session
+type ErrHttpStatus struct {
+	Url        string
+	StatusCode int
+}
+
+func (err *ErrHttpStatus) Error() string {
+	reason := "server error"
+	switch {
+	case err.StatusCode == 401 || err.StatusCode == 403:
+		reason = "not logged in or the session expired"
+	case err.StatusCode == 429:
+		reason = "too many requests"
+	}
+	return reason + " (status " + strconv.Itoa(err.StatusCode) + " from " + err.Url + ")"
+}
+
+// IsSessionExpired reports whether err is an ErrHttpStatus for a request that was not authorized
+func IsSessionExpired(err error) bool {
+	statusErr, ok := err.(*ErrHttpStatus)
+	return ok && (statusErr.StatusCode == 401 || statusErr.StatusCode == 403)
+}
+
+func (response *HttpResponse) url() string {
+	if response.Request != nil && response.Request.URL != nil {
+		return response.Request.URL.Scheme + "://" + response.Request.URL.Host + response.Request.URL.Path
+	}
+	return ""
+}
+
+// CheckStatus returns an ErrHttpStatus and closes the body for server errors, 401, 403 and 429.
+// Other statuses can still carry an answer, like the reason a code was rejected.
+func (response *HttpResponse) CheckStatus() error {
+	status := response.StatusCode
+	if status >= 500 || status == 401 || status == 403 || status == 429 {
+		response.Body.Close()
+		return &ErrHttpStatus{Url: response.url(), StatusCode: status}
+	}
+	return nil
+}
+
+// DecodeJson reads the body into v after CheckStatus. Fields tagged `required:"true"` have to be
+// present and not null, and every field has to have the right type, otherwise the error is an
+// ErrUnexpectedResponseShape.
+// Fields we do not know about are allowed, new fields do not break anything.
+func (response *HttpResponse) DecodeJson(v interface{}) error {
+	if err := response.CheckStatus(); err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	url := response.url()
+	payload, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return &ErrUnexpectedResponseShape{Url: url, Reason: "failed to read body"}
+	}
+	return decodeJson(url, payload, v)
+}
+
+func decodeJson(url string, payload []byte, v interface{}) error {
+	shapeError := func(reason string) error {
+		return &ErrUnexpectedResponseShape{Url: url, Reason: reason, Payload: payload}
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			field := "the body"
+			if typeErr.Field != "" {
+				field = "'" + typeErr.Field + "'"
+			}
+			return shapeError("expected " + typeErr.Type.Kind().String() + " for " + field + " but got " + typeErr.Value)
+		}
+		return shapeError("body is not json")
+	}
+	if reason := checkRequired(payload, reflect.TypeOf(v), ""); reason != "" {
+		return shapeError(reason)
+	}
+	if checked, ok := v.(ShapeChecker); ok {
+		if reason := checked.CheckShape(); reason != "" {
+			return shapeError(reason)
+		}
+	}
+	return nil
+}
+
+// ShapeChecker is for responses with rules that tags can not express, CheckShape
+// returns why the response is wrong or "" when it is fine
+type ShapeChecker interface {
+	CheckShape() string
+}
+
+// checkRequired walks the json along with the type it was decoded into and
+// returns why it does not match, or "" when it does
+func checkRequired(raw json.RawMessage, t reflect.Type, path string) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if isNull(raw) {
+		return ""
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return "expected an object at '" + path + "'"
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous {
+				if reason := checkRequired(raw, field.Type, path); reason != "" {
+					return reason
+				}
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" || field.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			fieldPath := joinPath(path, name)
+			value, found := fields[name]
+			if !found || isNull(value) {
+				if field.Tag.Get("required") == "true" {
+					return "missing '" + fieldPath + "'"
+				}
+				continue
+			}
+			if reason := checkRequired(value, field.Type, fieldPath); reason != "" {
+				return reason
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		items := make([]json.RawMessage, 0)
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return "expected a list at '" + path + "'"
+		}
+		for i, item := range items {
+			if reason := checkRequired(item, t.Elem(), path+"["+strconv.Itoa(i)+"]"); reason != "" {
+				return reason
+			}
+		}
+	}
+	return ""
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
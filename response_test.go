@@ -0,0 +1,111 @@
+package bl3_auto_vip
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type testShapeItem struct {
+	Id   int    `json:"id" required:"true"`
+	Name string `json:"name"`
+}
+
+type testShapeEmbedded struct {
+	Kind string `json:"kind" required:"true"`
+}
+
+type testShape struct {
+	testShapeEmbedded
+	Title  string          `json:"title" required:"true"`
+	Count  int             `json:"count"`
+	Owner  *testShapeItem  `json:"owner"`
+	Items  []testShapeItem `json:"items" required:"true"`
+	hidden string
+}
+
+type testCheckedShape struct {
+	Message   string `json:"message"`
+	Exception string `json:"exception"`
+}
+
+func (shape *testCheckedShape) CheckShape() string {
+	if shape.Message == "" && shape.Exception == "" {
+		return "missing 'message' and 'exception'"
+	}
+	return ""
+}
+
+func TestDecodeJson(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string
+	}{
+		{`{"kind": "a", "title": "t", "items": [{"id": 1}], "extra": true}`, ""},
+		{`{"kind": "a", "title": "t", "owner": null, "items": []}`, ""},
+		{`{"kind": "a", "items": []}`, "missing 'title'"},
+		{`{"kind": "a", "title": null, "items": []}`, "missing 'title'"},
+		{`{"title": "t", "items": []}`, "missing 'kind'"},
+		{`{"kind": "a", "title": "t", "count": "3", "items": []}`, "expected int for 'count' but got string"},
+		{`{"kind": "a", "title": "t", "items": [{"id": 1}, {"name": "x"}]}`, "missing 'items[1].id'"},
+		{`{"kind": "a", "title": "t", "owner": {"name": "x"}, "items": []}`, "missing 'owner.id'"},
+		{`[]`, "expected struct for the body but got array"},
+		{`<html>`, "body is not json"},
+	}
+	for _, test := range tests {
+		err := decodeJson("https://example.com/api", []byte(test.payload), &testShape{})
+		got := ""
+		if err != nil {
+			shapeErr, ok := err.(*ErrUnexpectedResponseShape)
+			if !ok {
+				t.Errorf("decodeJson(%s) returned %T, want *ErrUnexpectedResponseShape", test.payload, err)
+				continue
+			}
+			got = shapeErr.Reason
+			if string(shapeErr.Payload) != test.payload {
+				t.Errorf("decodeJson(%s) kept payload %q", test.payload, shapeErr.Payload)
+			}
+		}
+		if !strings.HasPrefix(got, test.want) || (got == "") != (test.want == "") {
+			t.Errorf("decodeJson(%s) = %q, want %q", test.payload, got, test.want)
+		}
+	}
+
+	if err := decodeJson("", []byte(`{"message": ""}`), &testCheckedShape{}); err == nil || err.(*ErrUnexpectedResponseShape).Reason != "missing 'message' and 'exception'" {
+		t.Errorf("decodeJson() with a failing CheckShape = %v", err)
+	}
+	if err := decodeJson("", []byte(`{"exception": "invalid"}`), &testCheckedShape{}); err != nil {
+		t.Errorf("decodeJson() with a passing CheckShape = %v", err)
+	}
+}
+
+func TestDecodeJsonStatus(t *testing.T) {
+	tests := []struct {
+		status     int
+		wantShape  bool
+		wantStatus bool
+	}{
+		{200, true, false},
+		{400, true, false},
+		{401, false, true},
+		{403, false, true},
+		{429, false, true},
+		{503, false, true},
+	}
+	for _, test := range tests {
+		response := &HttpResponse{http.Response{
+			StatusCode: test.status,
+			Body:       ioutil.NopCloser(strings.NewReader("<html>error</html>")),
+			Request:    &http.Request{URL: &url.URL{Scheme: "https", Host: "example.com", Path: "/api"}},
+		}}
+		err := response.DecodeJson(&testShape{})
+		if _, ok := err.(*ErrUnexpectedResponseShape); ok != test.wantShape {
+			t.Errorf("DecodeJson() with status %d = %v, want a shape error: %v", test.status, err, test.wantShape)
+		}
+		if _, ok := err.(*ErrHttpStatus); ok != test.wantStatus {
+			t.Errorf("DecodeJson() with status %d = %v, want a status error: %v", test.status, err, test.wantStatus)
+		}
+	}
+}
@@ -2,16 +2,74 @@ package bl3_auto_vip
 
 import (
 	"errors"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+type ShiftGame struct {
+	Codename string `json:"codename"`
+	Name string `json:"name"`
+	FeedTag string `json:"feedTag"`
+	Disabled bool `json:"disabled"`
+}
+
 type ShiftConfig struct {
 	CodeListUrl string `json:"codeListUrl"`
+	// CodeListTagUrl is the code list of a single game, {tag} is replaced by the game's feed tag
+	CodeListTagUrl string `json:"codeListTagUrl"`
 	CodeInfoUrl string `json:"codeInfoUrl"`
 	UserInfoUrl string `json:"userInfoUrl"`
 	GameCodename string `json:"gameCodename"`
+	Games []ShiftGame `json:"games"`
 	AllowInactive bool
+	// OnlyGames limits the enabled games to these codenames when not empty
+	OnlyGames StringSet
+}
+
+// GetGames returns the enabled games, falling back to GameCodename for older configs
+func (conf *ShiftConfig) GetGames() []ShiftGame {
+	if len(conf.Games) == 0 {
+		return []ShiftGame{{Codename: conf.GameCodename, Name: conf.GameCodename}}
+	}
+	games := make([]ShiftGame, 0)
+	for _, game := range conf.Games {
+		if len(conf.OnlyGames) > 0 {
+			if _, found := conf.OnlyGames[game.Codename]; !found {
+				continue
+			}
+		} else if game.Disabled {
+			continue
+		}
+		games = append(games, game)
+	}
+	return games
+}
+
+// FindGame looks up a game by codename or name
+func (conf *ShiftConfig) FindGame(s string) (ShiftGame, bool) {
+	for _, game := range conf.Games {
+		if strings.EqualFold(game.Codename, s) || strings.EqualFold(game.Name, s) {
+			return game, true
+		}
+	}
+	return ShiftGame{}, false
+}
+
+// GameName returns the human readable name of a game codename
+func (conf *ShiftConfig) GameName(codename string) string {
+	if game, found := conf.FindGame(codename); found && game.Name != "" {
+		return game.Name
+	}
+	return codename
+}
+
+func (conf *ShiftConfig) gameCodeListUrl(game ShiftGame) string {
+	if game.FeedTag == "" || conf.CodeListTagUrl == "" {
+		return conf.CodeListUrl
+	}
+	return strings.Replace(conf.CodeListTagUrl, "{tag}", game.FeedTag, -1)
 }
 
 type ShiftCodeMap map[string][]string
@@ -30,41 +88,209 @@ func (codeMap ShiftCodeMap) Contains(code, platform string) bool {
 }
 
 type shiftCode struct {
-	Game string `json:"offer_title"`
-	Platform string `json:"offer_service"`
-	Active bool `json:"is_active"`
+	Game string `json:"offer_title" required:"true"`
+	Platform string `json:"offer_service" required:"true"`
+	Active bool `json:"is_active" required:"true"`
+}
+
+type shiftCodeInfoResponse struct {
+	Codes []shiftCode `json:"entitlement_offer_codes"`
+	Errors []string `json:"errors"`
+}
+
+// unknown codes get errors instead of offers
+func (response *shiftCodeInfoResponse) CheckShape() string {
+	if response.Codes == nil && len(response.Errors) == 0 {
+		return "missing 'entitlement_offer_codes' and 'errors'"
+	}
+	return ""
+}
+
+type shiftRedemptionJobResponse struct {
+	JobId string `json:"job_id"`
+	Wait int `json:"max_wait_milliseconds"`
+	Error *struct {
+		Code string `json:"code" required:"true"`
+	} `json:"error"`
+}
+
+// a redemption either gets a job or an error
+func (response *shiftRedemptionJobResponse) CheckShape() string {
+	if response.JobId == "" && response.Error == nil {
+		return "missing 'job_id' and 'error'"
+	}
+	return ""
+}
+
+type shiftRedemptionResultResponse struct {
+	Success bool `json:"success" required:"true"`
+	Errors []string `json:"errors"`
+}
+
+type shiftUserInfoResponse struct {
+	Platforms []string `json:"platforms" required:"true"`
+}
+
+type shiftCodeListResponse []struct {
+	Codes []shiftCodeFromList `json:"codes" required:"true"`
 }
 
 type shiftCodeFromList struct {
-	Code string `json:"code"`
+	Code string `json:"code" required:"true"`
 	Platform string `json:"platform"`
+	Reward string `json:"reward"`
+	Source string `json:"source"`
+	Archived string `json:"archived"`
+	Expires string `json:"expires"`
 }
 
-func (client *Bl3Client) GetCodePlatforms(code string) ([]string, bool) {
-	platforms := make([]string, 0)
+// ShiftGameCodeMap groups codes by game codename
+type ShiftGameCodeMap map[string]ShiftCodeMap
+
+// ShiftFeedCode is a code from the SHIFT code list, times are zero when unknown
+type ShiftFeedCode struct {
+	Code string
+	Game string
+	Platform string
+	Reward string
+	Source string
+	Archived time.Time
+	Expires time.Time
+}
+
+func (code ShiftFeedCode) Expired(now time.Time) bool {
+	return !code.Expires.IsZero() && code.Expires.Before(now)
+}
+
+var feedTimeFormats = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// the feed uses "Unknown" and similar for missing dates
+func parseFeedTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, format := range feedTimeFormats {
+		if t, err := time.Parse(format, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseFeedExpiry reads an expiry date, a code without a time still works on its last day
+func parseFeedExpiry(s string) time.Time {
+	expires := parseFeedTime(s)
+	if _, err := time.Parse("2006-01-02", strings.TrimSpace(s)); err == nil {
+		expires = expires.AddDate(0, 0, 1)
+	}
+	return expires
+}
+
+// ErrNoCodePlatforms is returned for codes that can not be redeemed for any enabled game
+var ErrNoCodePlatforms = errors.New("no available redemption platforms")
+
+// ErrCodeRejected is returned for codes 2K does not know, or that were skipped because the
+// negative cache says they did not work before
+type ErrCodeRejected struct {
+	Reason string
+	Cached bool
+}
+
+func (err *ErrCodeRejected) Error() string {
+	if err.Cached {
+		return err.Reason + " (checked before)"
+	}
+	return err.Reason
+}
+
+// codeScope is what the platforms of a code depend on besides the code
+func (conf *ShiftConfig) codeScope() string {
+	games := make([]string, 0)
+	for _, game := range conf.GetGames() {
+		games = append(games, game.Codename)
+	}
+	sort.Strings(games)
+	scope := strings.Join(games, ",")
+	if conf.AllowInactive {
+		scope += "+inactive"
+	}
+	return scope
+}
+
+// GetCodeGamePlatforms returns the platforms the code can be redeemed on for each enabled game
+func (client *Bl3Client) GetCodeGamePlatforms(code string) (map[string][]string, error) {
+	gamePlatforms := make(map[string][]string)
+	scope := client.Config.Shift.codeScope()
+	if entry, found := client.NegativeCache.Get(KindShift, scope, code); found {
+		return gamePlatforms, &ErrCodeRejected{Reason: entry.Reason, Cached: true}
+	}
 
 	res, err := client.Get(client.Config.Shift.CodeInfoUrl + code + "/info")
 	if err != nil {
-		return platforms, false
+		return gamePlatforms, errors.New("failed to get code info")
+	}
+	if err := res.CheckStatus(); err != nil {
+		return gamePlatforms, err
 	}
+	status := res.StatusCode
 
-	json, err := res.BodyAsJson()
-	if err != nil {
-		return platforms, false
+	info := shiftCodeInfoResponse{}
+	if err := res.DecodeJson(&info); err != nil {
+		return gamePlatforms, err
+	}
+	if len(info.Errors) > 0 {
+		reason := strings.ToLower(strings.Join(strings.Split(info.Errors[0], "_"), " "))
+		// only a client error is about the code itself
+		if status >= 400 {
+			client.NegativeCache.Add(KindShift, scope, code, reason, NegativeTtlInvalid)
+		}
+		return gamePlatforms, &ErrCodeRejected{Reason: reason}
+	}
+	if status >= 300 {
+		return gamePlatforms, errors.New("failed to get code info (status " + strconv.Itoa(status) + ")")
 	}
 
-	codes := make([]shiftCode, 0)
-	json.From("entitlement_offer_codes").Select("offer_service", "is_active", "offer_title").Out(&codes)
-	for _, code := range codes {
-		if (code.Active || client.Config.Shift.AllowInactive) && code.Game == client.Config.Shift.GameCodename {
-			platforms = append(platforms, code.Platform)
+	enabled := StringSet{}
+	for _, game := range client.Config.Shift.GetGames() {
+		enabled.Add(game.Codename)
+	}
+
+	for _, code := range info.Codes {
+		if _, found := enabled[code.Game]; found && (code.Active || client.Config.Shift.AllowInactive) {
+			gamePlatforms[code.Game] = append(gamePlatforms[code.Game], code.Platform)
 		}
 	}
 
-	if len(platforms) == 0 {
+	if len(gamePlatforms) == 0 {
+		client.NegativeCache.Add(KindShift, scope, code, ErrNoCodePlatforms.Error(), NegativeTtlNoPlatforms)
+		return gamePlatforms, ErrNoCodePlatforms
+	}
+
+	client.NegativeCache.Remove(KindShift, scope, code)
+	return gamePlatforms, nil
+}
+
+func (client *Bl3Client) GetCodePlatforms(code string) ([]string, bool) {
+	platforms := make([]string, 0)
+	gamePlatforms, err := client.GetCodeGamePlatforms(code)
+	if err != nil {
 		return platforms, false
 	}
 
+	found := StringSet{}
+	for _, game := range client.Config.Shift.GetGames() {
+		for _, platform := range gamePlatforms[game.Codename] {
+			if _, dup := found[platform]; !dup {
+				found.Add(platform)
+				platforms = append(platforms, platform)
+			}
+		}
+	}
 	return platforms, true
 }
 
@@ -74,26 +300,13 @@ func (client *Bl3Client) RedeemShiftCode(code, platform string) error {
 		return errors.New("failed to initialize code redemption.")
 	}
 
-	type redemptionJob struct {
-		JobId string `json:"job_id"`
-		Wait int `json:"max_wait_milliseconds"`
-	}
-
-	resJson, err := response.BodyAsJson()
-	if err != nil {
-		return errors.New("bad code init response.")
+	redemptionInfo := shiftRedemptionJobResponse{}
+	if err := response.DecodeJson(&redemptionInfo); err != nil {
+		return err
 	}
 
-	redemptionInfo := redemptionJob{}
-	resJson.Out(&redemptionInfo)
-
 	if redemptionInfo.JobId == "" {
-		redemptionError := ""
-		resJson.Reset().From("error.code").Out(&redemptionError)
-		if redemptionError != "" {
-			return errors.New(strings.ToLower(strings.Join(strings.Split(redemptionError, "_"), " ")) + ". Try again later.")
-		}
-		return errors.New("failed to schedule code redemption.")
+		return errors.New(strings.ToLower(strings.Join(strings.Split(redemptionInfo.Error.Code, "_"), " ")) + ". Try again later.")
 	}
 	// not sure if this is necessary
 	time.Sleep(time.Duration(redemptionInfo.Wait) * time.Millisecond)
@@ -103,23 +316,16 @@ func (client *Bl3Client) RedeemShiftCode(code, platform string) error {
 		return errors.New("failed to initialize code redemption.")
 	}
 	
-	resJson, err = redeemResponse.BodyAsJson()
-	if err != nil {
-		return errors.New("bad code redemption response.")
+	result := shiftRedemptionResultResponse{}
+	if err := redeemResponse.DecodeJson(&result); err != nil {
+		return err
 	}
-
-	success := false
-	resJson.From("success").Out(&success)
-	errs := make([]string, 0)
-	resJson.Reset().From("errors").Out(&errs)
-	if len(errs) > 0 {
-		return errors.New(strings.ToLower(strings.Join(strings.Split(errs[0], "_"), " ")) + ".")
+	if len(result.Errors) > 0 {
+		return errors.New(strings.ToLower(strings.Join(strings.Split(result.Errors[0], "_"), " ")) + ".")
 	}
-	if !success {
+	if !result.Success {
 		return errors.New("failed to redeem shift code.")
 	}
-	
-	resJson.Out(&redemptionInfo)
 
 	return nil
 }
@@ -132,45 +338,153 @@ func (client *Bl3Client) GetShiftPlatforms() (StringSet, error) {
 		return platforms, errors.New("Failed to get available platforms list")
 	}
 
-	resJson, err := response.BodyAsJson()
-	if err != nil {
+	userInfo := shiftUserInfoResponse{}
+	if err := response.DecodeJson(&userInfo); err != nil {
 		return platforms, err
 	}
 
-	platformList := make([]string, 0)
-	resJson.From("platforms").Out(&platformList)
-
-	for _, platform := range platformList {
+	for _, platform := range userInfo.Platforms {
 		platforms.Add(platform)
 	}
 	return platforms, nil
 }
 
-func (client *Bl3Client) GetFullShiftCodeList() (ShiftCodeMap, error) {
-	codeMap := ShiftCodeMap{}
+// GetShiftCodeFeed gets the code list of every enabled game. A game whose code list can not be
+// read is left out with its error in the returned map, the error is only set when none could be read.
+func (client *Bl3Client) GetShiftCodeFeed() ([]ShiftFeedCode, map[string]error, error) {
+	feed := make([]ShiftFeedCode, 0)
+	feedErrors := make(map[string]error)
 	httpClient, err := NewHttpClient()
 	if err != nil {
-		return codeMap, err
+		return feed, feedErrors, err
 	}
 
-	res, err := httpClient.Get(client.Config.Shift.CodeListUrl)
-	if err != nil {
-		return codeMap, errors.New("Failed to get SHIFT code list")
+	// games can share a code list, it is only fetched once
+	urlErrors := make(map[string]error)
+	var firstErr error
+	read := 0
+	for _, game := range client.Config.Shift.GetGames() {
+		url := client.Config.Shift.gameCodeListUrl(game)
+		if err, found := urlErrors[url]; found {
+			if err != nil {
+				feedErrors[game.Codename] = err
+			}
+			continue
+		}
+
+		codes, err := getShiftCodeList(httpClient, url)
+		urlErrors[url] = err
+		if err != nil {
+			feedErrors[game.Codename] = err
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		read++
+
+		MetricCodesDiscovered.Add(float64(len(codes)), SourceShiftFeed)
+		for _, code := range codes {
+			normalized := NormalizeShiftCode(code.Code)
+			if normalized == "" {
+				continue
+			}
+			feed = append(feed, ShiftFeedCode{
+				Code: normalized,
+				Game: game.Codename,
+				Platform: code.Platform,
+				Reward: code.Reward,
+				Source: code.Source,
+				Archived: parseFeedTime(code.Archived),
+				Expires: parseFeedExpiry(code.Expires),
+			})
+		}
 	}
 
-	json, err := res.BodyAsJson()
+	if read == 0 && firstErr != nil {
+		return feed, feedErrors, firstErr
+	}
+	return feed, feedErrors, nil
+}
+
+func getShiftCodeList(httpClient *HttpClient, url string) ([]shiftCodeFromList, error) {
+	res, err := httpClient.Get(url)
 	if err != nil {
-		return codeMap, errors.New("Failed to get SHIFT code list body as JSON")
+		return nil, errors.New("Failed to get SHIFT code list")
 	}
 
-	codes := make([]shiftCodeFromList, 0)
-	json.From("[0].codes").Select("code", "platform").Out(&codes)
-	for _, code := range codes {
-		platforms, valid := client.GetCodePlatforms(code.Code)
-		if valid {
-			codeMap[code.Code] = platforms
+	list := shiftCodeListResponse{}
+	if err := res.DecodeJson(&list); err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, &ErrUnexpectedResponseShape{Url: url, Reason: "empty code list"}
+	}
+	return list[0].Codes, nil
+}
+
+// GetShiftCodePlatforms looks up the platforms of every code in the feed that has not expired.
+// A code is grouped under every enabled game it works for, not just the feed it came from.
+// With a ShiftCodeIndex only new and stale codes are looked up, the rest reuse the last answer.
+// It stops when the code info endpoint stops looking like it used to.
+func (client *Bl3Client) GetShiftCodePlatforms(feed []ShiftFeedCode) (ShiftGameCodeMap, ShiftLookupStats, error) {
+	gameCodeMap := ShiftGameCodeMap{}
+	stats := ShiftLookupStats{}
+	checked := StringSet{}
+	now := time.Now()
+	scope := client.Config.Shift.codeScope()
+	index := client.ShiftCodeIndex
+	index.see(feed, now)
+	defer index.Save()
+	for _, code := range feed {
+		if _, found := checked[code.Code]; found || code.Expired(now) {
+			continue
+		}
+		checked.Add(code.Code)
+
+		gamePlatforms, found := index.fresh(code.Code, scope, now)
+		if found {
+			stats.Reused++
+		} else {
+			var err error
+			gamePlatforms, err = client.GetCodeGamePlatforms(code.Code)
+			if IsUnexpectedResponseShape(err) {
+				return gameCodeMap, stats, err
+			}
+			if err != nil {
+				continue
+			}
+			stats.Looked++
+			index.checked(code.Code, scope, gamePlatforms, now)
+		}
+		for game, platforms := range gamePlatforms {
+			if _, found := gameCodeMap[game]; !found {
+				gameCodeMap[game] = ShiftCodeMap{}
+			}
+			gameCodeMap[game][code.Code] = platforms
 		}
 	}
+	return gameCodeMap, stats, nil
+}
 
+func (client *Bl3Client) GetFullShiftCodeList() (ShiftCodeMap, error) {
+	codeMap := ShiftCodeMap{}
+	feed, _, err := client.GetShiftCodeFeed()
+	if err != nil {
+		return codeMap, err
+	}
+	gameCodeMap, _, err := client.GetShiftCodePlatforms(feed)
+	if err != nil {
+		return codeMap, err
+	}
+	for _, codes := range gameCodeMap {
+		for code, platforms := range codes {
+			for _, platform := range platforms {
+				if !codeMap.Contains(code, platform) {
+					codeMap[code] = append(codeMap[code], platform)
+				}
+			}
+		}
+	}
 	return codeMap, nil
-}
\ No newline at end of file
+}
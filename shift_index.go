@@ -0,0 +1,185 @@
+package bl3_auto_vip
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultShiftCheckTtl is how long the platforms of a code are reused before they are checked again
+	DefaultShiftCheckTtl = 30 * 24 * time.Hour
+	// codes that have not been in the feed for this long are forgotten
+	shiftIndexForgetAfter = 90 * 24 * time.Hour
+)
+
+// ShiftSeenCode is a code from the SHIFT code list and what its platforms were when last checked
+type ShiftSeenCode struct {
+	Code      string    `json:"code"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// Checked is zero until the platforms were looked up
+	Checked time.Time `json:"checked"`
+	// Scope is the enabled games the platforms were looked up for
+	Scope         string              `json:"scope,omitempty"`
+	GamePlatforms map[string][]string `json:"gamePlatforms,omitempty"`
+	// what the code list said about the code when last seen, times are zero when unknown
+	Reward   string    `json:"reward,omitempty"`
+	Source   string    `json:"source,omitempty"`
+	Archived time.Time `json:"archived"`
+	Expires  time.Time `json:"expires"`
+}
+
+// ShiftCodeIndex remembers the codes seen in the SHIFT code list, so only new and stale
+// codes have to be looked up on each run
+type ShiftCodeIndex struct {
+	path  string
+	mu    sync.Mutex
+	codes map[string]*ShiftSeenCode
+	// Ttl is how long looked up platforms are reused, DefaultShiftCheckTtl when zero
+	Ttl time.Duration
+	// Recheck looks up every code, while still updating the index
+	Recheck bool
+}
+
+// ShiftLookupStats counts the codes of a GetShiftCodePlatforms call that were looked up, and
+// the ones that reused the platforms from the index
+type ShiftLookupStats struct {
+	Looked int
+	Reused int
+}
+
+func OpenShiftCodeIndex(path string) (*ShiftCodeIndex, error) {
+	index := &ShiftCodeIndex{path: path, codes: make(map[string]*ShiftSeenCode)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, errors.New("Failed to open SHIFT code index")
+	}
+	codes := make([]*ShiftSeenCode, 0)
+	if err := json.Unmarshal(data, &codes); err != nil {
+		// it is only a cache, starting over is fine
+		return index, nil
+	}
+	for _, code := range codes {
+		index.codes[strings.ToUpper(code.Code)] = code
+	}
+	return index, nil
+}
+
+// see marks the codes as being in the feed, remembering when they first showed up
+func (index *ShiftCodeIndex) see(feed []ShiftFeedCode, now time.Time) {
+	if index == nil {
+		return
+	}
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	for _, feedCode := range feed {
+		key := strings.ToUpper(feedCode.Code)
+		code, found := index.codes[key]
+		if !found {
+			code = &ShiftSeenCode{Code: feedCode.Code, FirstSeen: now}
+			index.codes[key] = code
+		}
+		code.LastSeen = now
+		if feedCode.Reward != "" {
+			code.Reward = feedCode.Reward
+		}
+		if feedCode.Source != "" {
+			code.Source = feedCode.Source
+		}
+		if !feedCode.Archived.IsZero() {
+			code.Archived = feedCode.Archived
+		}
+		if !feedCode.Expires.IsZero() {
+			code.Expires = feedCode.Expires
+		}
+	}
+}
+
+// fresh returns the platforms of a code when they were looked up for the same games within the ttl
+func (index *ShiftCodeIndex) fresh(code, scope string, now time.Time) (map[string][]string, bool) {
+	if index == nil || index.Recheck {
+		return nil, false
+	}
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	ttl := index.Ttl
+	if ttl <= 0 {
+		ttl = DefaultShiftCheckTtl
+	}
+	seen, found := index.codes[strings.ToUpper(code)]
+	if !found || seen.Checked.IsZero() || seen.Scope != scope || now.Sub(seen.Checked) > ttl {
+		return nil, false
+	}
+	return seen.GamePlatforms, true
+}
+
+// checked saves the platforms a code was just looked up with
+func (index *ShiftCodeIndex) checked(code, scope string, gamePlatforms map[string][]string, now time.Time) {
+	if index == nil {
+		return
+	}
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	key := strings.ToUpper(code)
+	seen, found := index.codes[key]
+	if !found {
+		seen = &ShiftSeenCode{Code: code, FirstSeen: now, LastSeen: now}
+		index.codes[key] = seen
+	}
+	seen.Checked = now
+	seen.Scope = scope
+	seen.GamePlatforms = gamePlatforms
+}
+
+// Save writes the index, leaving out codes that have been gone from the feed for a long time
+func (index *ShiftCodeIndex) Save() error {
+	if index == nil {
+		return nil
+	}
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	now := time.Now()
+	codes := make([]*ShiftSeenCode, 0, len(index.codes))
+	for key, code := range index.codes {
+		if now.Sub(code.LastSeen) > shiftIndexForgetAfter {
+			delete(index.codes, key)
+			continue
+		}
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if !codes[i].FirstSeen.Equal(codes[j].FirstSeen) {
+			return codes[i].FirstSeen.Before(codes[j].FirstSeen)
+		}
+		return codes[i].Code < codes[j].Code
+	})
+	data, err := json.MarshalIndent(codes, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(index.path), 0755); err != nil {
+		return errors.New("Failed to write SHIFT code index")
+	}
+	tmpPath := index.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return errors.New("Failed to write SHIFT code index")
+	}
+	if err := os.Rename(tmpPath, index.path); err != nil {
+		return errors.New("Failed to write SHIFT code index")
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package bl3_auto_vip
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShiftCodeIndexFresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shift-codes-seen.json")
+	index, err := OpenShiftCodeIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checked := time.Now().Add(-time.Hour)
+	platforms := map[string][]string{"oak": {"steam", "psn"}}
+	index.see([]ShiftFeedCode{{Code: "ABCDE-12345-FGHIJ-67890-KLMNO"}, {Code: "NEWCO-12345-FGHIJ-67890-KLMNO"}}, checked)
+	index.checked("ABCDE-12345-FGHIJ-67890-KLMNO", "oak", platforms, checked)
+
+	tests := []struct {
+		code  string
+		scope string
+		now   time.Time
+		want  bool
+	}{
+		{"ABCDE-12345-FGHIJ-67890-KLMNO", "oak", checked, true},
+		{"abcde-12345-fghij-67890-klmno", "oak", checked.Add(DefaultShiftCheckTtl), true},
+		{"ABCDE-12345-FGHIJ-67890-KLMNO", "oak", checked.Add(DefaultShiftCheckTtl + time.Second), false},
+		{"ABCDE-12345-FGHIJ-67890-KLMNO", "oak,willow2", checked, false},
+		{"NEWCO-12345-FGHIJ-67890-KLMNO", "oak", checked, false},
+		{"OTHER-12345-FGHIJ-67890-KLMNO", "oak", checked, false},
+	}
+	check := func(name string, index *ShiftCodeIndex) {
+		for _, test := range tests {
+			got, found := index.fresh(test.code, test.scope, test.now)
+			if found != test.want {
+				t.Errorf("%s: fresh(%q, %q, checked+%v) = %v, want %v", name, test.code, test.scope, test.now.Sub(checked), found, test.want)
+			}
+			if found && !reflect.DeepEqual(got, platforms) {
+				t.Errorf("%s: fresh(%q) = %v, want %v", name, test.code, got, platforms)
+			}
+		}
+	}
+	check("checked", index)
+	if err := index.Save(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenShiftCodeIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("reopened", reopened)
+
+	reopened.Ttl = time.Minute
+	if _, found := reopened.fresh("ABCDE-12345-FGHIJ-67890-KLMNO", "oak", checked.Add(2*time.Minute)); found {
+		t.Errorf("fresh() ignored the Ttl")
+	}
+	reopened.Ttl = 0
+	reopened.Recheck = true
+	if _, found := reopened.fresh("ABCDE-12345-FGHIJ-67890-KLMNO", "oak", checked); found {
+		t.Errorf("fresh() with Recheck = true")
+	}
+}
@@ -0,0 +1,36 @@
+package bl3_auto_vip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFeedExpiry(t *testing.T) {
+	tests := []struct {
+		expires string
+		want    time.Time
+	}{
+		{"2019-10-31", time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{" 2019-10-31 ", time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"2019-10-31 10:00:00", time.Date(2019, 10, 31, 10, 0, 0, 0, time.UTC)},
+		{"Thu, 31 Oct 2019 10:00:00 +0000", time.Date(2019, 10, 31, 10, 0, 0, 0, time.UTC)},
+		{"Unknown", time.Time{}},
+		{"", time.Time{}},
+	}
+	for _, test := range tests {
+		if got := parseFeedExpiry(test.expires); !got.Equal(test.want) {
+			t.Errorf("parseFeedExpiry(%q) = %v, want %v", test.expires, got, test.want)
+		}
+	}
+
+	code := ShiftFeedCode{Expires: parseFeedExpiry("2019-10-31")}
+	if code.Expired(time.Date(2019, 10, 31, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("a code expiring on 2019-10-31 expired during its last day")
+	}
+	if !code.Expired(time.Date(2019, 11, 1, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("a code expiring on 2019-10-31 did not expire the day after")
+	}
+	if (ShiftFeedCode{}).Expired(time.Now()) {
+		t.Errorf("a code without an expiry expired")
+	}
+}
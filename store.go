@@ -0,0 +1,360 @@
+package bl3_auto_vip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	KindShift = "shift"
+	KindVip   = "vip"
+)
+
+const (
+	ResultRedeemed        = "redeemed"
+	ResultAlreadyRedeemed = "already redeemed"
+	ResultExpired         = "expired"
+	ResultInvalid         = "invalid"
+	ResultFailed          = "failed"
+)
+
+const (
+	SourceShiftFeed   = "shift feed"
+	SourceVipCodeList = "vip code list"
+	SourceManual      = "manual"
+	SourceServer      = "server"
+	SourceMigration   = "migration"
+)
+
+// RedemptionRecord is a single attempt at redeeming a code
+type RedemptionRecord struct {
+	Account  string    `json:"account"`
+	Code     string    `json:"code"`
+	Kind     string    `json:"kind"`
+	Platform string    `json:"platform"`       // SHIFT platform or VIP code type
+	Game     string    `json:"game,omitempty"` // SHIFT game codename
+	Result   string    `json:"result"`
+	Message  string    `json:"message,omitempty"`
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"`
+	// Expires is when the code stops working, if known
+	Expires *time.Time `json:"expires,omitempty"`
+	// Reward is what the SHIFT code list says the code gives
+	Reward string `json:"reward,omitempty"`
+}
+
+// Expired reports whether the code is known to have stopped working
+func (record RedemptionRecord) Expired(now time.Time) bool {
+	return record.Expires != nil && record.Expires.Before(now)
+}
+
+// Done reports whether the code should not be tried again on the same platform
+func (record RedemptionRecord) Done() bool {
+	switch record.Result {
+	case ResultRedeemed, ResultAlreadyRedeemed, ResultExpired:
+		return true
+	}
+	return false
+}
+
+type RedemptionStore interface {
+	Add(record RedemptionRecord) error
+	// Records returns every record for the account, or all records when account is empty
+	Records(account string) ([]RedemptionRecord, error)
+	// Prune removes every record the function returns true for and returns how many were removed
+	Prune(remove func(RedemptionRecord) bool) (int, error)
+	Close() error
+}
+
+// JsonlRedemptionStore keeps records as one JSON object per line in an append-only file
+type JsonlRedemptionStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func OpenJsonlRedemptionStore(path string) (*JsonlRedemptionStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.New("Failed to create redemption history folder")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, errors.New("Failed to open redemption history")
+	}
+	file.Close()
+	return &JsonlRedemptionStore{path: path}, nil
+}
+
+func (store *JsonlRedemptionStore) Add(record RedemptionRecord) error {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	data, err := json.Marshal(&record)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	file, err := os.OpenFile(store.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.New("Failed to open redemption history")
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+func (store *JsonlRedemptionStore) Records(account string) ([]RedemptionRecord, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.readRecords(account)
+}
+
+// readRecords expects the lock to be held
+func (store *JsonlRedemptionStore) readRecords(account string) ([]RedemptionRecord, error) {
+	records := make([]RedemptionRecord, 0)
+
+	file, err := os.Open(store.path)
+	if err != nil {
+		return records, errors.New("Failed to open redemption history")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		record := RedemptionRecord{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			// a partially written line should not make the whole history unreadable
+			continue
+		}
+		if account == "" || record.Account == account {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+func (store *JsonlRedemptionStore) Prune(remove func(RedemptionRecord) bool) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	records, err := store.readRecords("")
+	if err != nil {
+		return 0, err
+	}
+
+	buffer := bytes.Buffer{}
+	removed := 0
+	for _, record := range records {
+		if remove(record) {
+			removed++
+			continue
+		}
+		data, err := json.Marshal(&record)
+		if err != nil {
+			return 0, err
+		}
+		buffer.Write(append(data, '\n'))
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	// write a copy first so a crash can not lose the whole history
+	tmpPath := store.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buffer.Bytes(), 0644); err != nil {
+		return 0, errors.New("Failed to write redemption history")
+	}
+	if err := os.Rename(tmpPath, store.path); err != nil {
+		return 0, errors.New("Failed to write redemption history")
+	}
+	return removed, nil
+}
+
+func (store *JsonlRedemptionStore) Close() error {
+	return nil
+}
+
+// RedemptionFilter selects records, empty fields match everything
+type RedemptionFilter struct {
+	Account  string
+	Since    time.Time
+	Until    time.Time
+	Kind     string
+	Platform string // SHIFT platform
+	CodeType string // VIP code type
+	Result   string
+}
+
+func (filter RedemptionFilter) Match(record RedemptionRecord) bool {
+	if filter.Account != "" && record.Account != filter.Account {
+		return false
+	}
+	if !filter.Since.IsZero() && record.Time.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && !record.Time.Before(filter.Until) {
+		return false
+	}
+	if filter.Kind != "" && record.Kind != filter.Kind {
+		return false
+	}
+	if filter.Platform != "" && (record.Kind != KindShift || !strings.EqualFold(record.Platform, filter.Platform)) {
+		return false
+	}
+	if filter.CodeType != "" && (record.Kind != KindVip || !strings.EqualFold(record.Platform, filter.CodeType)) {
+		return false
+	}
+	if filter.Result != "" && record.Result != filter.Result {
+		return false
+	}
+	return true
+}
+
+func FilterRecords(records []RedemptionRecord, filter RedemptionFilter) []RedemptionRecord {
+	filtered := make([]RedemptionRecord, 0)
+	for _, record := range records {
+		if filter.Match(record) {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered
+}
+
+// RedeemedShiftCodes builds the map of SHIFT codes that are done for the account and have not expired
+func RedeemedShiftCodes(store RedemptionStore, account string) (ShiftCodeMap, error) {
+	codeMap := ShiftCodeMap{}
+	records, err := store.Records(account)
+	if err != nil {
+		return codeMap, err
+	}
+	now := time.Now()
+	for _, record := range records {
+		if record.Kind == KindShift && record.Done() && !record.Expired(now) && !codeMap.Contains(record.Code, record.Platform) {
+			codeMap[record.Code] = append(codeMap[record.Code], record.Platform)
+		}
+	}
+	return codeMap, nil
+}
+
+// RedeemedVipCodes builds the map of VIP codes that are done for the account
+func (conf *Bl3Config) RedeemedVipCodes(store RedemptionStore, account string) (VipCodeMap, error) {
+	codeMap := conf.NewVipCodeMap()
+	records, err := store.Records(account)
+	if err != nil {
+		return codeMap, err
+	}
+	for _, record := range records {
+		if record.Kind == KindVip && record.Done() {
+			codeMap.Add(record.Platform, record.Code)
+		}
+	}
+	return codeMap, nil
+}
+
+// NewestRecordTime returns the time of the newest record of the kind for the account that is done,
+// or zero when there is none. Migrated records are left out, their time is when they were migrated.
+func NewestRecordTime(store RedemptionStore, account, kind string) (time.Time, error) {
+	newest := time.Time{}
+	records, err := store.Records(account)
+	if err != nil {
+		return newest, err
+	}
+	for _, record := range records {
+		if record.Source == SourceMigration {
+			continue
+		}
+		if record.Kind == kind && record.Done() && record.Time.After(newest) {
+			newest = record.Time
+		}
+	}
+	return newest, nil
+}
+
+// MigrateShiftCodeMap imports a legacy SHIFT code cache as redeemed records
+func MigrateShiftCodeMap(store RedemptionStore, account string, codeMap ShiftCodeMap) error {
+	now := time.Now()
+	for code, platforms := range codeMap {
+		for _, platform := range platforms {
+			err := store.Add(RedemptionRecord{
+				Account:  account,
+				Code:     code,
+				Kind:     KindShift,
+				Platform: platform,
+				Result:   ResultRedeemed,
+				Time:     now,
+				Source:   SourceMigration,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MigrateVipCodeMap imports a legacy VIP code cache as redeemed records
+func MigrateVipCodeMap(store RedemptionStore, account string, codeMap VipCodeMap) error {
+	now := time.Now()
+	for codeType, codes := range codeMap {
+		for code := range codes {
+			err := store.Add(RedemptionRecord{
+				Account:  account,
+				Code:     code,
+				Kind:     KindVip,
+				Platform: codeType,
+				Result:   ResultRedeemed,
+				Time:     now,
+				Source:   SourceMigration,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ShiftRedemptionResult classifies the error returned by RedeemShiftCode
+func ShiftRedemptionResult(err error) string {
+	if err == nil {
+		return ResultRedeemed
+	}
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "already") {
+		return ResultAlreadyRedeemed
+	}
+	if strings.Contains(msg, "has expired") {
+		return ResultExpired
+	}
+	return ResultFailed
+}
+
+// VipRedemptionResult classifies the values returned by RedeemVipCode
+func VipRedemptionResult(message string, valid bool) string {
+	if !valid {
+		// RedeemVipCode only reports a rejected code as not valid when the server calls it invalid
+		if strings.Contains(strings.ToLower(message), "invalid") && message != "invalid response" {
+			return ResultInvalid
+		}
+		return ResultFailed
+	}
+	if strings.Contains(strings.ToLower(message), "already") {
+		return ResultAlreadyRedeemed
+	}
+	return ResultRedeemed
+}
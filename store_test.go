@@ -0,0 +1,118 @@
+package bl3_auto_vip
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewestRecordTime(t *testing.T) {
+	store, err := OpenJsonlRedemptionStore(filepath.Join(t.TempDir(), "redemptions.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := time.Date(2019, 10, 3, 17, 0, 0, 0, time.UTC)
+	records := []RedemptionRecord{
+		{Account: "a", Code: "old", Kind: KindVip, Result: ResultRedeemed, Time: server.AddDate(0, 0, -7), Source: SourceVipCodeList},
+		{Account: "a", Code: "server", Kind: KindVip, Result: ResultRedeemed, Time: server, Source: SourceServer},
+		{Account: "a", Code: "migrated", Kind: KindVip, Result: ResultRedeemed, Time: server.AddDate(1, 0, 0), Source: SourceMigration},
+		{Account: "a", Code: "failed", Kind: KindVip, Result: ResultFailed, Time: server.AddDate(1, 0, 0), Source: SourceVipCodeList},
+		{Account: "a", Code: "shift", Kind: KindShift, Result: ResultRedeemed, Time: server.AddDate(1, 0, 0), Source: SourceShiftFeed},
+		{Account: "b", Code: "other", Kind: KindVip, Result: ResultRedeemed, Time: server.AddDate(1, 0, 0), Source: SourceVipCodeList},
+		{Account: "c", Code: "migrated", Kind: KindVip, Result: ResultRedeemed, Time: server, Source: SourceMigration},
+	}
+	for _, record := range records {
+		if err := store.Add(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		account string
+		want    time.Time
+	}{
+		{"a", server},
+		{"b", server.AddDate(1, 0, 0)},
+		{"c", time.Time{}},
+		{"d", time.Time{}},
+	}
+	for _, test := range tests {
+		got, err := NewestRecordTime(store, test.account, KindVip)
+		if err != nil || !got.Equal(test.want) {
+			t.Errorf("NewestRecordTime(%q) = %v, %v, want %v", test.account, got, err, test.want)
+		}
+	}
+}
+
+func TestJsonlRedemptionStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history", "redemptions.jsonl")
+	store, err := OpenJsonlRedemptionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Date(2019, 10, 31, 0, 0, 0, 0, time.UTC)
+	added := []RedemptionRecord{
+		{Account: "a", Code: "ABCDE-12345-FGHIJ-67890-KLMNO", Kind: KindShift, Platform: "steam", Game: "oak",
+			Result: ResultRedeemed, Message: "ok", Time: time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC),
+			Source: SourceShiftFeed, Expires: &expires, Reward: "3 Golden Keys"},
+		{Account: "a", Code: "abc123", Kind: KindVip, Platform: "vault", Result: ResultFailed,
+			Time: time.Date(2019, 10, 2, 0, 0, 0, 0, time.UTC), Source: SourceVipCodeList},
+		{Account: "b", Code: "def456", Kind: KindVip, Platform: "vault", Result: ResultRedeemed,
+			Time: time.Date(2019, 10, 3, 0, 0, 0, 0, time.UTC), Source: SourceManual},
+	}
+	for _, record := range added {
+		if err := store.Add(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Add(RedemptionRecord{Account: "c", Code: "now"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a partially written line is skipped
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"account": "a", "code": "trunc`)
+	file.Close()
+
+	reopened, err := OpenJsonlRedemptionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		account string
+		want    []RedemptionRecord
+	}{
+		{"a", added[:2]},
+		{"b", added[2:]},
+		{"d", []RedemptionRecord{}},
+	}
+	for _, test := range tests {
+		got, err := reopened.Records(test.account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Records(%q) = %+v, want %+v", test.account, got, test.want)
+		}
+	}
+	all, err := reopened.Records("")
+	if err != nil || len(all) != 4 {
+		t.Errorf("Records(\"\") = %d records, %v, want 4", len(all), err)
+	} else if all[3].Time.IsZero() {
+		t.Errorf("Add() without a time kept it zero")
+	}
+
+	removed, err := reopened.Prune(func(record RedemptionRecord) bool { return !record.Done() })
+	if err != nil || removed != 2 {
+		t.Errorf("Prune() = %d, %v, want 2", removed, err)
+	}
+	got, err := reopened.Records("")
+	if err != nil || len(got) != 2 || got[0].Code != added[0].Code || got[1].Code != added[2].Code {
+		t.Errorf("Records() after Prune() = %+v, %v", got, err)
+	}
+}
@@ -19,12 +19,12 @@ func JsonFromBytes(bytes []byte) *gojsonq.JSONQ {
 }
 
 type Bl3Config struct {
-	Version string `json:"version"`
-	LoginUrl string `json:"loginUrl"`
-	LoginRedirectHeader string `json:"loginRedirectHeader"`
-	SessionIdHeader string `json:"sessionIdHeader"`
+	Version string `json:"version" required:"true"`
+	LoginUrl string `json:"loginUrl" required:"true"`
+	LoginRedirectHeader string `json:"loginRedirectHeader" required:"true"`
+	SessionIdHeader string `json:"sessionIdHeader" required:"true"`
 	RequestHeaders map[string]string `json:"requestHeaders"`
-	SessionHeader string `json:"sessionHeader"`
-	Vip VipConfig `json:"vipConfig"`
-	Shift ShiftConfig `json:"shiftConfig"`
+	SessionHeader string `json:"sessionHeader" required:"true"`
+	Vip VipConfig `json:"vipConfig" required:"true"`
+	Shift ShiftConfig `json:"shiftConfig" required:"true"`
 }
\ No newline at end of file
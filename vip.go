@@ -1,12 +1,18 @@
 package bl3_auto_vip
 
 import (
+	"encoding/json"
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/PuerkitoBio/goquery"
-	"github.com/thedevsaddam/gojsonq"
+	"github.com/matt1484/bl3_auto_vip/crowdtwist"
 )
 
 type VipCodeMap map[string]StringSet
@@ -37,16 +43,37 @@ func (v VipCodeMap) Add(codeType, code string) {
 type VipActivity struct {
 	Title string `json:"title"`
 	Link string `json:"link_href"`
+	Name string `json:"name"`
+	// Capped is true when the activity was claimed as often as allowed until NextAvailable
+	Capped bool `json:"-"`
+	NextAvailable time.Time `json:"-"`
 }
 
 type VipConfig struct {
 	CodeListUrl string `json:"codeListUrl"`
 	CodeListRowSelector string `json:"codeListRowSelector"`
+	CodeListTableSelector string `json:"codeListTableSelector"`
+	// CodeListColumns are the header texts of the code, type and check columns,
+	// the index fields are only used when no table has a code column header
+	CodeListColumns map[string][]string `json:"codeListColumns"`
+	// CodeListInvalidRegex is text the check column of rows with codes that no longer work contains.
+	// Older versions read it from the same config, CodeListInvalidPattern replaces it when set.
 	CodeListInvalidRegex string `json:"codeListInvalidRegex"`
+	// CodeListInvalidPattern is a regex matching the check column of rows with codes that no longer work
+	CodeListInvalidPattern string `json:"codeListInvalidPattern"`
+	// CodeListCodeRegex has to match a code for it to be used
+	CodeListCodeRegex string `json:"codeListCodeRegex"`
+	CodeListTypeOverrides []VipCodeTypeOverride `json:"codeListTypeOverrides"`
 	CodeListCheckIndex int `json:"codeListCheckIndex"`
 	CodeListCodeIndex int `json:"codeListCodeIndex"`
 	CodeListTypeIndex int `json:"codeListTypeIndex"`
 	CodeTypeUrlMap map[string]string  `json:"codeTypeUrlMap"`
+	ActivityRules []VipActivityRule `json:"activityRules"`
+	ActivityReset VipActivityReset `json:"activityReset"`
+	// ActivityPageSize is how many account activities are requested at a time
+	ActivityPageSize int `json:"activityPageSize"`
+	invalidRegex *regexp.Regexp
+	codeRegex *regexp.Regexp
 }
 
 func (conf *VipConfig) GetCodeTypes() []string {
@@ -77,243 +104,376 @@ func (conf *Bl3Config) NewVipCodeMap() VipCodeMap {
 }
 
 func (client *Bl3Client) GetFullVipCodeMap() (VipCodeMap, error) {
-	codeMap := client.Config.NewVipCodeMap()
-	httpClient, err := NewHttpClient()
-	if err != nil {
-		return codeMap, err
-	}
+	codeMap, _, err := client.ScrapeVipCodeList()
+	return codeMap, err
+}
 
-	response, err := httpClient.Get(client.Config.Vip.CodeListUrl)
-	if err != nil {
-		return codeMap, errors.New("Failed to get code list")
-	}
+// DefaultVipActivityPageSize is how many activities are requested at a time when the config does not say
+const DefaultVipActivityPageSize = 100
 
-	codeHtml, err := response.BodyAsHtmlDoc()
-	if err != nil {
-		return codeMap, err
+// vipActivityTimeMargin allows for activity times without a time zone and clocks that differ
+const vipActivityTimeMargin = 24 * time.Hour
+
+func (conf *VipConfig) activityPageSize() int {
+	if conf.ActivityPageSize > 0 {
+		return conf.ActivityPageSize
 	}
+	return DefaultVipActivityPageSize
+}
 
-	codeHtml.Find(client.Config.Vip.CodeListRowSelector).Each(func(i int, row *goquery.Selection) {
-		numColumns := len(row.Find("td").Nodes)
-		if numColumns < client.Config.Vip.CodeListCheckIndex || 
-			numColumns < client.Config.Vip.CodeListCodeIndex || 
-			numColumns < client.Config.Vip.CodeListTypeIndex {
-			return
-		}
+// VipCodeTimes is when VIP codes were redeemed, by code type and code
+type VipCodeTimes map[string]time.Time
 
-		codeTypes := ""
-		code := ""
+func (v VipCodeTimes) Set(codeType, code string, t time.Time) {
+	v[strings.ToLower(codeType) + ":" + strings.ToLower(code)] = t
+}
 
-		row.Find("td").EachWithBreak(func(i int, col *goquery.Selection) bool {
-			if i == client.Config.Vip.CodeListCheckIndex && 
-			    strings.Contains(strings.ToLower(col.Text()), client.Config.Vip.CodeListInvalidRegex) {
-				return false
-			}
-			if i == client.Config.Vip.CodeListCodeIndex {
-				code = strings.TrimSpace(strings.ToLower(col.Text()))
-			}
-			if i == client.Config.Vip.CodeListTypeIndex {
-				codeTypes = strings.ToLower(col.Text())
-				return false
-			}
-			return true
-		})
+// Get returns when the code was redeemed, or zero when it is not known
+func (v VipCodeTimes) Get(codeType, code string) time.Time {
+	return v[strings.ToLower(codeType) + ":" + strings.ToLower(code)]
+}
+
+// crowdtwist gives activity times like "2019-10-03 17:37:45" in UTC, the rest are in case that changes
+var crowdtwistTimeFormats = []string{
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02",
+}
 
-		for _, codeType := range client.Config.Vip.DetectCodeTypes(codeTypes) {
-			codeMap[codeType].Add(code)
+// parseCrowdtwistTime reads a crowdtwist time, which may also be in unix seconds, or returns zero
+func parseCrowdtwistTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil && seconds > 0 {
+		return time.Unix(seconds, 0).UTC()
+	}
+	for _, format := range crowdtwistTimeFormats {
+		if t, err := time.Parse(format, s); err == nil {
+			return t
 		}
+	}
+	return time.Time{}
+}
+
+// AddRedeemedVipCodes adds the codes redeemed on the server to codeMap and when they were redeemed
+// to times, newest first and a page at a time. When since is not zero it stops at the first
+// activity from before since, which is meant to be the newest redemption already known locally.
+// It returns how many activities had a time that could not be read, those never stop it early.
+func (client *Bl3Client) AddRedeemedVipCodes(codeMap VipCodeMap, times VipCodeTimes, since time.Time) (int, error) {
+	if !since.IsZero() {
+		since = since.Add(-vipActivityTimeMargin)
+	}
+	undated := 0
+	pageSize := client.Config.Vip.activityPageSize()
+	err := client.crowdtwist().EachUserActivity(crowdtwist.WidgetUser, pageSize, func(activity crowdtwist.UserActivity) bool {
+		created := parseCrowdtwistTime(activity.DateCreated)
+		if created.IsZero() {
+			undated++
+		} else if !since.IsZero() && created.Before(since) {
+			return false
+		}
+		if activity.Notes == "" {
+			return true
+		}
+		for _, codeType := range client.Config.Vip.DetectCodeTypes(activity.Title) {
+			codeMap.Add(codeType, activity.Notes)
+			if !created.IsZero() && times != nil {
+				times.Set(codeType, activity.Notes, created)
+			}
+		}
+		return true
 	})
-	return codeMap, nil
+	return undated, err
 }
 
+// GetRedeemedVipCodeMap gets every code redeemed on the server
 func (client *Bl3Client) GetRedeemedVipCodeMap() (VipCodeMap, error) {
 	codeMap := client.Config.NewVipCodeMap()
+	_, err := client.AddRedeemedVipCodes(codeMap, nil, time.Time{})
+	return codeMap, err
+}
 
-	url := "https://2kgames.crowdtwist.com/request?widgetId=9470"
-	data := map[string]interface{}{
-		"model_data": map[string]interface{}{
-			"activity": map[string]interface{}{
-				"newest_activities": map[string]interface{}{
-					"properties": []string{"notes", "title"},
-					"query": map[string]interface{}{
-						"type": "user_activities_me",
-						"args": map[string]int{
-							"row_start": 1,
-							"row_end":   1000000,
-						},
-					},
-				},
-			},
-		},
-	}
-
-	res, err := client.PostJson(url, data)
-	if err != nil {
-		return codeMap, errors.New("Failed to get redeemed code list")
-	}
+type VipPointActivity struct {
+	Title string
+	Notes string
+	Points int
+	Time time.Time
+}
 
-	type activity struct {
-		CodeTypes string `json:"title"`
-		Code      string `json:"notes"`
+type VipStatus struct {
+	Points int
+	LifetimePoints int
+	Tier string
+	Recent []VipPointActivity
+}
+
+// GetVipStatus gets the account's points, tier and the newest point earning activities
+func (client *Bl3Client) GetVipStatus(recent int) (VipStatus, error) {
+	status := VipStatus{
+		Recent: make([]VipPointActivity, 0),
 	}
 
-	activities := make([]activity, 0)
-	resJson, err := res.BodyAsJson()
+	request := crowdtwist.NewRequest().Me()
+	if recent > 0 {
+		request.UserActivitiesMe(1, recent)
+	}
+	response, err := client.crowdtwist().Post(crowdtwist.WidgetUser, request)
 	if err != nil {
-		return codeMap, err
+		return status, err
 	}
 
-	resJson.From("model_data.activity.newest_activities").Out(&activities)
-
-	for _, act := range activities {
-		for _, codeType := range client.Config.Vip.DetectCodeTypes(act.CodeTypes) {
-			codeMap.Add(codeType, act.Code)
-		}
+	me := response.ModelData.User.Me
+	status.Points = me.Points
+	status.LifetimePoints = me.LifetimePoints
+	status.Tier = me.TierName
+
+	for _, act := range response.ModelData.Activity.NewestActivities {
+		status.Recent = append(status.Recent, VipPointActivity{
+			Title: act.Title,
+			Notes: act.Notes,
+			Points: act.Points,
+			Time: parseCrowdtwistTime(act.DateCreated),
+		})
 	}
-	return codeMap, nil
+	return status, nil
 }
 
-func (client *Bl3Client) getVipWidgetConf(url string) *gojsonq.JSONQ {
-	response, err := client.Get(url)
+// CheckVipWidgetConf checks that the activity list widget config can still be read
+func (client *Bl3Client) CheckVipWidgetConf() error {
+	conf, err := client.crowdtwist().ActivityList(crowdtwist.WidgetActivityList)
 	if err != nil {
-		return nil
+		return err
 	}
-
-	widgetHtml, err := response.BodyAsHtmlDoc()
-	if err != nil {
-		return nil
+	if len(conf.Entries) == 0 {
+		return errors.New("widget config has no entries")
 	}
-
-	script := ""
-
-	widgetHtml.Find("script").EachWithBreak(func(i int, scriptTag *goquery.Selection) bool {
-		if strings.Contains(scriptTag.Text(), "widgetConf") {
-			script = scriptTag.Text()
-			return false
-		}
-		return true
-	})
-
-	script = strings.TrimSpace(strings.Split(strings.Join(strings.Split(strings.Join(strings.Split(script, "widgetConf")[1:], ""), "=")[1:], ""), ";")[0])
-	json := JsonFromString(script)
-	return json
+	return nil
 }
 
 func (client *Bl3Client) GenerateVipCodeUrlMap() (map[string]string, error) {
 	codeTypeUrlMap := make(map[string]string)
 
-	widgetConf := client.getVipWidgetConf("https://2kgames.crowdtwist.com/widgets/t/activity-list/9904/?__locale__=en#2")
-	if widgetConf == nil {
-		return codeTypeUrlMap, errors.New("Failed to get code redemption types")
-	}
-
-	type widget struct {
-		WidgetId   int    `json:"widgetId"`
-		WidgetName string `json:"widgetName"`
+	ct := client.crowdtwist()
+	conf, err := ct.ActivityList(crowdtwist.WidgetCodeTypes)
+	if err != nil {
+		return codeTypeUrlMap, errors.New("Failed to get code redemption types: " + err.Error())
 	}
 
-	widgets := make([]widget, 0)
-	widgetConf.From("entries").Select("link.widgetId", "link.widgetName").Out(&widgets)
-
-	for _, wid := range widgets {
-		for _, codeType := range client.Config.Vip.DetectCodeTypes(wid.WidgetName) {
-			widgetConf := client.getVipWidgetConf("https://2kgames.crowdtwist.com/widgets/t/code-redemption/" + strconv.Itoa(wid.WidgetId))
-			if widgetConf == nil {
-				codeTypeUrlMap[codeType] = ""
-				continue
+	for _, entry := range conf.Entries {
+		codeTypes := client.Config.Vip.DetectCodeTypes(entry.Link.WidgetName)
+		if len(codeTypes) == 0 {
+			if codeType := vipCodeTypeName(entry.Link.WidgetName); codeType != "" {
+				codeTypes = append(codeTypes, codeType)
 			}
-
-			campaignId, ok := widgetConf.Find("campaignId").(float64)
-			if !ok {
+		}
+		for _, codeType := range codeTypes {
+			redemptionConf, err := ct.CodeRedemption(entry.Link.WidgetId)
+			if err != nil || redemptionConf.CampaignId == 0 {
 				codeTypeUrlMap[codeType] = ""
 				continue
 			}
-			codeTypeUrlMap[codeType] = "https://2kgames.crowdtwist.com/code-redemption-campaign/redeem?cid=" + strconv.Itoa(int(campaignId))
+			codeTypeUrlMap[codeType] = ct.CampaignUrl(redemptionConf.CampaignId)
 		}
 	}
 	
 	return codeTypeUrlMap, nil
 }
 
-func (client *Bl3Client) GetVipActivities() ([]VipActivity, error) {
-	activities := make([]VipActivity, 0)
-	widgetConf := client.getVipWidgetConf("https://2kgames.crowdtwist.com/widgets/t/activity-list/9446?__locale__=en")
-	if widgetConf == nil {
-		return activities, errors.New("failed to get activity names")
+// vipCodeTypeName guesses the name of a code type the config does not know from its widget name,
+// which is the first word that is not one every widget has, like "Creator" in "Creator Code Redemption"
+func vipCodeTypeName(widgetName string) string {
+	for _, word := range strings.FieldsFunc(strings.ToLower(widgetName), func(r rune) bool {
+		return r < 'a' || r > 'z'
+	}) {
+		switch word {
+		case "vip", "code", "codes", "redemption", "redeem", "widget":
+			continue
+		}
+		return word
 	}
-	
-	type activity struct {
-		Name string `json:"name"`
-	}
-	activityNames := make([]activity, 0)
-	widgetConf.From("entries").Select("activity.name").Out(&activityNames)
-
-	names := make([]string, len(activityNames))
-	for i, activity := range activityNames {
-		names[i] = activity.Name
-	}
-	url := "https://2kgames.crowdtwist.com/request?widgetId=9446"
-	data := map[string]interface{}{
-		"model_data": map[string]interface{}{
-			"activity": map[string]interface{}{
-				"activities": map[string]interface{}{
-					"properties": []string{"title", "link_href", "user_activity_status"},
-					"query": map[string]interface{}{
-						"type": "activities_by_name",
-						"args": map[string]interface{}{
-							"names": names,
-						},
-					},
-				},
-			},
-		},
-	}
-	response, err := client.PostJson(url, data)
+	return ""
+}
+
+// vipCodeUrlCache is the discovered code type map saved between runs
+type vipCodeUrlCache struct {
+	Updated time.Time `json:"updated"`
+	CodeTypeUrlMap map[string]string `json:"codeTypeUrlMap"`
+}
+
+// DiscoverVipCodeUrls replaces the configured code redemption urls with the ones found on crowdtwist,
+// reusing the discovered map saved at cachePath while it is younger than ttl. Configured urls are
+// kept for any type that could not be discovered and types only crowdtwist knows are added. The
+// returned warnings list where the two disagree and whether the discovered map could not be saved.
+func (client *Bl3Client) DiscoverVipCodeUrls(cachePath string, ttl time.Duration) ([]string, error) {
+	warnings := make([]string, 0)
+
+	cache := vipCodeUrlCache{}
+	data, err := ioutil.ReadFile(cachePath)
+	if err != nil || json.Unmarshal(data, &cache) != nil || time.Since(cache.Updated) > ttl {
+		discovered, err := client.GenerateVipCodeUrlMap()
+		if err != nil {
+			return warnings, err
+		}
+		cache = vipCodeUrlCache{
+			Updated: time.Now(),
+			CodeTypeUrlMap: discovered,
+		}
+		if err := saveVipCodeUrlCache(cachePath, &cache); err != nil {
+			warnings = append(warnings, "could not save the discovered VIP code types, they are discovered again next run: " + err.Error())
+		}
+	}
+
+	if client.Config.Vip.CodeTypeUrlMap == nil {
+		client.Config.Vip.CodeTypeUrlMap = make(map[string]string)
+	}
+	for codeType, discovered := range cache.CodeTypeUrlMap {
+		if _, found := client.Config.Vip.CodeTypeUrlMap[codeType]; found || discovered == "" {
+			continue
+		}
+		warnings = append(warnings, "found '" + codeType + "' VIP codes that are not in the config, using " + discovered)
+		client.Config.Vip.CodeTypeUrlMap[codeType] = discovered
+	}
+
+	for _, codeType := range client.Config.Vip.GetCodeTypes() {
+		configured := client.Config.Vip.CodeTypeUrlMap[codeType]
+		discovered := cache.CodeTypeUrlMap[codeType]
+		if discovered == "" {
+			warnings = append(warnings, "could not discover the url for '" + codeType + "' VIP codes, using " + configured)
+			continue
+		}
+		if discovered != configured {
+			warnings = append(warnings, "the '" + codeType + "' VIP code url changed from " + configured + " to " + discovered)
+			client.Config.Vip.CodeTypeUrlMap[codeType] = discovered
+		}
+	}
+	sort.Strings(warnings)
+	return warnings, nil
+}
+
+func saveVipCodeUrlCache(cachePath string, cache *vipCodeUrlCache) error {
+	data, err := json.Marshal(cache)
 	if err != nil {
-		return activities, errors.New("failed to get activities")
+		return err
 	}
-	responseJson, err := response.BodyAsJson()
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cachePath, data, 0644)
+}
+
+// ErrVipActivityNotCredited is returned when claiming an activity did not register with 2K
+var ErrVipActivityNotCredited = errors.New("the activity was not credited")
+
+// getVipActivities gets every activity along with its status for the account
+func (client *Bl3Client) getVipActivities() ([]crowdtwist.Activity, error) {
+	ct := client.crowdtwist()
+	conf, err := ct.ActivityList(crowdtwist.WidgetActivityList)
 	if err != nil {
-		return activities, errors.New("failed to get activities")
+		return nil, errors.New("failed to get activity names: " + err.Error())
 	}
-	responseJson.From("model_data.activity.activities").Where("user_activity_status.has_reached_freq_cap", "=", false).Select("title", "link_href").Out(&activities)
 
+	names := make([]string, len(conf.Entries))
+	for i, entry := range conf.Entries {
+		names[i] = entry.Activity.Name
+	}
+	response, err := ct.Post(crowdtwist.WidgetActivityList, crowdtwist.NewRequest().ActivitiesByName(names))
+	if err != nil {
+		return nil, err
+	}
+	return response.ModelData.Activity.Activities, nil
+}
+
+// GetVipActivities gets every activity, capped ones included with the time they can be claimed again
+func (client *Bl3Client) GetVipActivities() ([]VipActivity, error) {
+	activities := make([]VipActivity, 0)
+	results, err := client.getVipActivities()
+	if err != nil {
+		return activities, err
+	}
+	now := time.Now()
+	for _, result := range results {
+		activity := VipActivity{Title: result.Title, Link: result.LinkHref, Name: result.Name}
+		if result.UserActivityStatus.HasReachedFreqCap {
+			activity.Capped = true
+			activity.NextAvailable = client.Config.Vip.nextAvailable(result.UserActivityStatus, now)
+		}
+		activities = append(activities, activity)
+	}
 	return activities, nil
 }
 
-func (client *Bl3Client) RedeemVipActivity(activity VipActivity) bool {
+// vipActivityCheckRows is how many of the newest point earning activities are compared around a claim
+const vipActivityCheckRows = 10
+
+// vipActivitySnapshot gets the points and newest point earning activities of the account
+func (client *Bl3Client) vipActivitySnapshot() (*crowdtwist.Response, error) {
+	return client.crowdtwist().Post(crowdtwist.WidgetUser, crowdtwist.NewRequest().Me().UserActivitiesMe(1, vipActivityCheckRows))
+}
+
+// credited reports whether the snapshot after a claim shows the activity was counted: a new entry
+// in the activity history with its title or name, or more points than before
+func (activity VipActivity) credited(before, after *crowdtwist.Response) bool {
+	seen := StringSet{}
+	for _, entry := range before.ModelData.Activity.NewestActivities {
+		seen.Add(entry.Title + "|" + entry.DateCreated + "|" + entry.Notes)
+	}
+	for _, entry := range after.ModelData.Activity.NewestActivities {
+		if _, found := seen[entry.Title+"|"+entry.DateCreated+"|"+entry.Notes]; found {
+			continue
+		}
+		title := strings.ToLower(entry.Title)
+		if strings.EqualFold(entry.Title, activity.Title) || activity.Name != "" && strings.Contains(title, strings.ToLower(activity.Name)) {
+			return true
+		}
+	}
+	return after.ModelData.User.Me.Points > before.ModelData.User.Me.Points
+}
+
+// RedeemVipActivity claims the activity and checks that 2K counted it, which shows as a new entry in
+// the account's activity history or a points increase. Activities can often be claimed more than once
+// a period, so reaching the frequency cap is not required.
+func (client *Bl3Client) RedeemVipActivity(activity VipActivity) error {
+	before, err := client.vipActivitySnapshot()
+	if err != nil {
+		return errors.New("failed to get activity history: " + err.Error())
+	}
+
 	response, err := client.Get(activity.Link)
 	if err != nil {
-		return false
+		return errors.New("failed to open activity link")
+	}
+	response.Body.Close()
+
+	// crediting can lag a little behind the claim
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(2 * time.Second)
+		}
+		after, err := client.vipActivitySnapshot()
+		if err != nil {
+			return errors.New("failed to verify activity: " + err.Error())
+		}
+		if activity.credited(before, after) {
+			return nil
+		}
 	}
-	defer response.Body.Close()
-	return true
+	return ErrVipActivityNotCredited
 }
 
 func (client *Bl3Client) RedeemVipCode(codeType, code string) (string, bool) {
-	res, err := client.PostJson(client.Config.Vip.CodeTypeUrlMap[codeType], map[string]string {
-		"code": code,
-	})
-	if err != nil {
-		return "bad request", false
+	response, err := client.crowdtwist().RedeemCode(client.Config.Vip.CodeTypeUrlMap[codeType], code)
+	if IsUnexpectedResponseShape(err) {
+		return err.Error(), false
 	}
-
-	resJson, err := res.BodyAsJson()
 	if err != nil {
-		return "invalid response", false
+		return "bad request", false
 	}
 
-	exception := ""
-	resJson.From("exception.model").Out(&exception)
-	success := ""
-	resJson.Reset().From("message").Out(&success)
-	if exception != "" {
+	if response.Exception != nil {
+		exception := response.Exception.Model
 		// technically the code may be valid but just unredeemable by this account (limits/already redeemed)
 		return exception, !strings.Contains(strings.ToLower(exception), "invalid")
 	}
-	if success != "" {
-		return success, true
-	}
-
-	return "wrong response format", false
-}
\ No newline at end of file
+	return response.Message, true
+}
@@ -0,0 +1,272 @@
+package bl3_auto_vip
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	ColumnCode  = "code"
+	ColumnType  = "type"
+	ColumnCheck = "check"
+)
+
+// DefaultVipCodeListColumns are the header texts each column is known by, compared case insensitively
+var DefaultVipCodeListColumns = map[string][]string{
+	ColumnCode:  {"code", "codes", "vip code"},
+	ColumnType:  {"type", "code type", "redeem at", "where"},
+	ColumnCheck: {"valid", "still valid", "working", "works", "active"},
+}
+
+// VipCodeTypeOverride gives rows whose type column matches Pattern the code type Type,
+// instead of looking for code type names in it
+type VipCodeTypeOverride struct {
+	Pattern string `json:"pattern"`
+	Type    string `json:"type"`
+	regex   *regexp.Regexp
+}
+
+// compileRegex compiles a config regex case insensitively, naming the field when it is wrong
+func compileRegex(field, pattern string) (*regexp.Regexp, error) {
+	regex, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, errors.New("invalid " + field + " '" + pattern + "': " + err.Error())
+	}
+	return regex, nil
+}
+
+// CompileCodeListRules compiles the regexes that decide which code list rows are used
+func (conf *VipConfig) CompileCodeListRules() error {
+	var err error
+	conf.invalidRegex, conf.codeRegex = nil, nil
+	switch {
+	case conf.CodeListInvalidPattern != "":
+		if conf.invalidRegex, err = compileRegex("codeListInvalidPattern", conf.CodeListInvalidPattern); err != nil {
+			return err
+		}
+	case conf.CodeListInvalidRegex != "":
+		// despite the name it has always been plain text the column contains
+		conf.invalidRegex = regexp.MustCompile("(?i)" + regexp.QuoteMeta(conf.CodeListInvalidRegex))
+	}
+	if conf.CodeListCodeRegex != "" {
+		if conf.codeRegex, err = compileRegex("codeListCodeRegex", conf.CodeListCodeRegex); err != nil {
+			return err
+		}
+	}
+	for i := range conf.CodeListTypeOverrides {
+		override := &conf.CodeListTypeOverrides[i]
+		if override.Type == "" {
+			return errors.New("invalid codeListTypeOverrides entry '" + override.Pattern + "': missing type")
+		}
+		override.Type = strings.ToLower(override.Type)
+		if _, found := conf.CodeTypeUrlMap[override.Type]; !found {
+			return errors.New("invalid codeListTypeOverrides entry '" + override.Pattern + "': unknown code type '" + override.Type + "'")
+		}
+		if override.regex, err = compileRegex("codeListTypeOverrides pattern", override.Pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowCodeTypes returns the code types of a row from the text of its type column
+func (conf *VipConfig) rowCodeTypes(text string) []string {
+	for _, override := range conf.CodeListTypeOverrides {
+		if override.regex != nil && override.regex.MatchString(text) {
+			return []string{override.Type}
+		}
+	}
+	return conf.DetectCodeTypes(text)
+}
+
+// VipCodeTable describes a table the code list was read from. Columns are -1 when not found.
+type VipCodeTable struct {
+	Index       int
+	ByHeader    bool
+	Headers     []string
+	CodeColumn  int
+	TypeColumn  int
+	CheckColumn int
+	Rows        int
+	Codes       int
+}
+
+func (table VipCodeTable) String() string {
+	columnName := func(column int) string {
+		switch {
+		case column < 0:
+			return "none"
+		case column < len(table.Headers) && table.Headers[column] != "":
+			return strconv.Itoa(column) + " '" + table.Headers[column] + "'"
+		}
+		return strconv.Itoa(column)
+	}
+	how := "fixed column positions"
+	if table.ByHeader {
+		how = "headers"
+	}
+	return "table " + strconv.Itoa(table.Index+1) + " by " + how +
+		" (code " + columnName(table.CodeColumn) +
+		", type " + columnName(table.TypeColumn) +
+		", check " + columnName(table.CheckColumn) + "): " +
+		strconv.Itoa(table.Codes) + " codes in " + strconv.Itoa(table.Rows) + " rows"
+}
+
+func (conf *VipConfig) columnAliases(column string) []string {
+	if aliases, found := conf.CodeListColumns[column]; found && len(aliases) > 0 {
+		return aliases
+	}
+	return DefaultVipCodeListColumns[column]
+}
+
+// findColumn prefers a header that is exactly an alias over one that only contains it
+func findColumn(headers []string, aliases []string, taken map[int]bool) int {
+	for _, alias := range aliases {
+		for i, header := range headers {
+			if !taken[i] && header == strings.ToLower(alias) {
+				return i
+			}
+		}
+	}
+	for _, alias := range aliases {
+		for i, header := range headers {
+			if !taken[i] && strings.Contains(header, strings.ToLower(alias)) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func cellTexts(row *goquery.Selection) []string {
+	texts := make([]string, 0)
+	row.Find("th, td").Each(func(i int, cell *goquery.Selection) {
+		texts = append(texts, strings.TrimSpace(cell.Text()))
+	})
+	return texts
+}
+
+// mapTable finds the columns of a table by its header row
+func (conf *VipConfig) mapTable(index int, table *goquery.Selection) (VipCodeTable, *goquery.Selection, bool) {
+	rows := table.Find("tr")
+	headerRow := rows.Has("th").First()
+	if headerRow.Length() == 0 {
+		headerRow = rows.First()
+	}
+	headers := cellTexts(headerRow)
+	normalized := make([]string, len(headers))
+	for i, header := range headers {
+		normalized[i] = strings.ToLower(header)
+	}
+
+	mapped := VipCodeTable{Index: index, ByHeader: true, Headers: headers}
+	taken := make(map[int]bool)
+	mapped.CodeColumn = findColumn(normalized, conf.columnAliases(ColumnCode), taken)
+	if mapped.CodeColumn < 0 {
+		return mapped, nil, false
+	}
+	taken[mapped.CodeColumn] = true
+	mapped.TypeColumn = findColumn(normalized, conf.columnAliases(ColumnType), taken)
+	taken[mapped.TypeColumn] = true
+	mapped.CheckColumn = findColumn(normalized, conf.columnAliases(ColumnCheck), taken)
+	return mapped, rows.NotSelection(headerRow), true
+}
+
+// readRows adds the codes of the rows to the code map
+func (client *Bl3Client) readRows(table *VipCodeTable, rows *goquery.Selection, codeMap VipCodeMap) {
+	rows.Each(func(i int, row *goquery.Selection) {
+		cells := make([]string, 0)
+		row.Find("td").Each(func(i int, cell *goquery.Selection) {
+			cells = append(cells, cell.Text())
+		})
+		if table.CodeColumn >= len(cells) {
+			return
+		}
+		table.Rows++
+
+		conf := &client.Config.Vip
+		if conf.invalidRegex != nil && table.CheckColumn >= 0 && table.CheckColumn < len(cells) &&
+			conf.invalidRegex.MatchString(strings.TrimSpace(cells[table.CheckColumn])) {
+			return
+		}
+
+		code := ""
+		for _, candidate := range ExtractCodes(cells[table.CodeColumn], conf.GetCodeTypes()) {
+			if candidate.Kind == KindVip && (conf.codeRegex == nil || conf.codeRegex.MatchString(candidate.Code)) {
+				code = candidate.Code
+				break
+			}
+		}
+		if code == "" {
+			return
+		}
+
+		// without a type column the type can still be mentioned somewhere in the row
+		codeTypes := strings.ToLower(strings.Join(cells, " "))
+		if table.TypeColumn >= 0 && table.TypeColumn < len(cells) {
+			codeTypes = strings.ToLower(cells[table.TypeColumn])
+		}
+		for _, codeType := range conf.rowCodeTypes(codeTypes) {
+			if _, found := codeMap[codeType]; !found {
+				continue
+			}
+			codeMap[codeType].Add(code)
+			table.Codes++
+			MetricCodesDiscovered.Inc(SourceVipCodeList)
+		}
+	})
+}
+
+// ScrapeVipCodeList reads the VIP codes from every table in the code list whose headers name a
+// code column. When none does it falls back to the fixed column positions of the config.
+func (client *Bl3Client) ScrapeVipCodeList() (VipCodeMap, []VipCodeTable, error) {
+	codeMap := client.Config.NewVipCodeMap()
+	tables := make([]VipCodeTable, 0)
+	httpClient, err := NewHttpClient()
+	if err != nil {
+		return codeMap, tables, err
+	}
+
+	response, err := httpClient.Get(client.Config.Vip.CodeListUrl)
+	if err != nil {
+		return codeMap, tables, errors.New("Failed to get code list")
+	}
+
+	codeHtml, err := response.BodyAsHtmlDoc()
+	if err != nil {
+		return codeMap, tables, err
+	}
+	return codeMap, client.readVipCodeList(codeHtml, codeMap), nil
+}
+
+// readVipCodeList adds the codes of a code list page to codeMap and returns the tables they came from
+func (client *Bl3Client) readVipCodeList(codeHtml *goquery.Document, codeMap VipCodeMap) []VipCodeTable {
+	tables := make([]VipCodeTable, 0)
+	tableSelector := client.Config.Vip.CodeListTableSelector
+	if tableSelector == "" {
+		tableSelector = "table"
+	}
+	codeHtml.Find(tableSelector).Each(func(i int, tableHtml *goquery.Selection) {
+		table, rows, found := client.Config.Vip.mapTable(i, tableHtml)
+		if !found {
+			return
+		}
+		client.readRows(&table, rows, codeMap)
+		tables = append(tables, table)
+	})
+	if len(tables) > 0 {
+		return tables
+	}
+
+	table := VipCodeTable{
+		CodeColumn:  client.Config.Vip.CodeListCodeIndex,
+		TypeColumn:  client.Config.Vip.CodeListTypeIndex,
+		CheckColumn: client.Config.Vip.CodeListCheckIndex,
+	}
+	client.readRows(&table, codeHtml.Find(client.Config.Vip.CodeListRowSelector), codeMap)
+	return append(tables, table)
+}
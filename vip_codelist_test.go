@@ -0,0 +1,127 @@
+package bl3_auto_vip
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestFindColumn(t *testing.T) {
+	tests := []struct {
+		headers []string
+		aliases []string
+		taken   map[int]bool
+		want    int
+	}{
+		{[]string{"code", "type"}, []string{"code"}, nil, 0},
+		{[]string{"code type", "code"}, []string{"code"}, nil, 1},
+		{[]string{"vip code", "type"}, []string{"code", "codes"}, nil, 0},
+		{[]string{"codes", "code"}, []string{"code", "codes"}, nil, 1},
+		{[]string{"code", "code type"}, []string{"type", "code type"}, map[int]bool{0: true}, 1},
+		{[]string{"code", "redeem"}, []string{"type"}, nil, -1},
+		{[]string{"code"}, []string{"code"}, map[int]bool{0: true}, -1},
+		{[]string{}, []string{"code"}, nil, -1},
+	}
+	for _, test := range tests {
+		taken := test.taken
+		if taken == nil {
+			taken = map[int]bool{}
+		}
+		if got := findColumn(test.headers, test.aliases, taken); got != test.want {
+			t.Errorf("findColumn(%q, %q, %v) = %d, want %d", test.headers, test.aliases, test.taken, got, test.want)
+		}
+	}
+}
+
+func testVipCodeListClient(t *testing.T) *Bl3Client {
+	client := &Bl3Client{}
+	client.Config.Vip.CodeTypeUrlMap = map[string]string{"vault": "", "diamond": "", "creator": ""}
+	client.Config.Vip.CodeListInvalidPattern = "^(no|expired)\\b"
+	client.Config.Vip.CodeListCodeIndex = 0
+	client.Config.Vip.CodeListCheckIndex = 1
+	client.Config.Vip.CodeListTypeIndex = 2
+	client.Config.Vip.CodeListRowSelector = "tbody tr"
+	if err := client.Config.Vip.CompileCodeListRules(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func readTestVipCodeList(t *testing.T, client *Bl3Client, page string) (VipCodeMap, []VipCodeTable) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codeMap := client.Config.NewVipCodeMap()
+	return codeMap, client.readVipCodeList(doc, codeMap)
+}
+
+func sortedVipCodes(codeMap VipCodeMap) []string {
+	codes := make([]string, 0)
+	for codeType, typeCodes := range codeMap {
+		for code := range typeCodes {
+			codes = append(codes, codeType+":"+code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func TestReadVipCodeListByHeader(t *testing.T) {
+	page := `<table>
+		<thead><tr><th>Redeem at</th><th>Still valid?</th><th>Code</th></tr></thead>
+		<tbody>
+			<tr><td>Vault Insider</td><td>Yes</td><td>VAULT1</td></tr>
+			<tr><td>Diamond</td><td>No, expired</td><td>DIA2</td></tr>
+			<tr><td>Diamond</td><td>unknown</td><td>DIA3</td></tr>
+		</tbody>
+	</table>
+	<table><tr><th>Title</th><th>Link</th></tr><tr><td>news</td><td>x</td></tr></table>
+	<table>
+		<tr><td>VIP Code</td><td>Type</td></tr>
+		<tr><td>CREATE4</td><td>creator</td></tr>
+	</table>`
+	codeMap, tables := readTestVipCodeList(t, testVipCodeListClient(t), page)
+
+	want := []string{"creator:create4", "diamond:dia3", "vault:vault1"}
+	if got := sortedVipCodes(codeMap); !reflect.DeepEqual(got, want) {
+		t.Errorf("readVipCodeList() codes = %q, want %q", got, want)
+	}
+	wantTables := []VipCodeTable{
+		{Index: 0, ByHeader: true, Headers: []string{"Redeem at", "Still valid?", "Code"}, CodeColumn: 2, TypeColumn: 0, CheckColumn: 1, Rows: 3, Codes: 2},
+		{Index: 2, ByHeader: true, Headers: []string{"VIP Code", "Type"}, CodeColumn: 0, TypeColumn: 1, CheckColumn: -1, Rows: 1, Codes: 1},
+	}
+	if !reflect.DeepEqual(tables, wantTables) {
+		t.Errorf("readVipCodeList() tables = %+v, want %+v", tables, wantTables)
+	}
+}
+
+func TestReadVipCodeListFixedColumns(t *testing.T) {
+	page := `<table><tbody>
+		<tr><td>VAULT1</td><td>yes</td><td>vault</td></tr>
+		<tr><td>DIA2</td><td>no</td><td>diamond</td></tr>
+		<tr><td>CREATE4</td><td>yes</td></tr>
+	</tbody></table>`
+	client := testVipCodeListClient(t)
+	codeMap, tables := readTestVipCodeList(t, client, page)
+
+	want := []string{"vault:vault1"}
+	if got := sortedVipCodes(codeMap); !reflect.DeepEqual(got, want) {
+		t.Errorf("readVipCodeList() codes = %q, want %q", got, want)
+	}
+	wantTables := []VipCodeTable{{CodeColumn: 0, TypeColumn: 2, CheckColumn: 1, Rows: 3, Codes: 1}}
+	if !reflect.DeepEqual(tables, wantTables) {
+		t.Errorf("readVipCodeList() tables = %+v, want %+v", tables, wantTables)
+	}
+
+	// configured header names replace the defaults
+	client.Config.Vip.CodeListColumns = map[string][]string{ColumnCode: {"key"}}
+	page = `<table><tr><th>Key</th><th>Type</th></tr><tr><td>VAULT9</td><td>vault</td></tr></table>`
+	codeMap, tables = readTestVipCodeList(t, client, page)
+	if got := sortedVipCodes(codeMap); !reflect.DeepEqual(got, []string{"vault:vault9"}) || len(tables) != 1 || !tables[0].ByHeader {
+		t.Errorf("readVipCodeList() with codeListColumns = %q, %+v", got, tables)
+	}
+}
@@ -0,0 +1,121 @@
+package bl3_auto_vip
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const (
+	RuleInclude = "include"
+	RuleExclude = "exclude"
+)
+
+// VipActivityRule decides whether VIP activities get claimed. Field is title, link, name or any.
+// Pattern is a case insensitive glob (* and ?), or a regex when it starts with "re:".
+type VipActivityRule struct {
+	Action  string `json:"action"`
+	Field   string `json:"field"`
+	Pattern string `json:"pattern"`
+	regex   *regexp.Regexp
+}
+
+// DefaultVipActivityRules skip the video activities, which can not be claimed without watching them
+var DefaultVipActivityRules = []VipActivityRule{
+	{Action: RuleExclude, Field: "title", Pattern: "*watch*"},
+	{Action: RuleExclude, Field: "link", Pattern: "*video*"},
+}
+
+// ParseVipActivityRule reads a rule written as field:pattern, e.g. "title:*watch*" or "link:re:video$"
+func ParseVipActivityRule(action, s string) (VipActivityRule, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return VipActivityRule{}, errors.New("invalid VIP activity rule '" + s + "', expected field:pattern")
+	}
+	rule := VipActivityRule{Action: action, Field: parts[0], Pattern: parts[1]}
+	return rule, rule.compile()
+}
+
+func (rule *VipActivityRule) compile() error {
+	rule.Action = strings.ToLower(rule.Action)
+	rule.Field = strings.ToLower(rule.Field)
+	if rule.Action != RuleInclude && rule.Action != RuleExclude {
+		return errors.New("invalid VIP activity rule action '" + rule.Action + "', expected include or exclude")
+	}
+	switch rule.Field {
+	case "title", "link", "name", "any":
+	default:
+		return errors.New("invalid VIP activity rule field '" + rule.Field + "', expected title, link, name or any")
+	}
+
+	expr := ""
+	if strings.HasPrefix(rule.Pattern, "re:") {
+		expr = "(?i)" + strings.TrimPrefix(rule.Pattern, "re:")
+	} else {
+		expr = "(?i)^" + strings.Replace(strings.Replace(regexp.QuoteMeta(rule.Pattern), `\*`, ".*", -1), `\?`, ".", -1) + "$"
+	}
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return errors.New("invalid VIP activity rule pattern '" + rule.Pattern + "': " + err.Error())
+	}
+	rule.regex = regex
+	return nil
+}
+
+func (rule *VipActivityRule) Matches(activity VipActivity) bool {
+	if rule.regex == nil && rule.compile() != nil {
+		return false
+	}
+	switch rule.Field {
+	case "title":
+		return rule.regex.MatchString(activity.Title)
+	case "link":
+		return rule.regex.MatchString(activity.Link)
+	case "name":
+		return rule.regex.MatchString(activity.Name)
+	}
+	return rule.regex.MatchString(activity.Title) || rule.regex.MatchString(activity.Link) || rule.regex.MatchString(activity.Name)
+}
+
+func (rule VipActivityRule) String() string {
+	return rule.Action + " " + rule.Field + ":" + rule.Pattern
+}
+
+// CompileActivityRules checks the configured rules, using the defaults when there are none
+func (conf *VipConfig) CompileActivityRules() error {
+	if len(conf.ActivityRules) == 0 {
+		conf.ActivityRules = append([]VipActivityRule{}, DefaultVipActivityRules...)
+	}
+	for i := range conf.ActivityRules {
+		if err := conf.ActivityRules[i].compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimsActivity reports whether the rules allow claiming the activity and the rule that decided it.
+// With include rules an activity has to match one of them, and it must not match any exclude rule.
+func (conf *VipConfig) ClaimsActivity(activity VipActivity) (bool, string) {
+	hasIncludes := false
+	included := ""
+	for i := range conf.ActivityRules {
+		rule := &conf.ActivityRules[i]
+		if rule.Action == RuleInclude {
+			hasIncludes = true
+			if included == "" && rule.Matches(activity) {
+				included = rule.String()
+			}
+		}
+	}
+	for i := range conf.ActivityRules {
+		rule := &conf.ActivityRules[i]
+		if rule.Action == RuleExclude && rule.Matches(activity) {
+			return false, rule.String()
+		}
+	}
+	if hasIncludes && included == "" {
+		return false, "no include rule matched"
+	}
+	return true, included
+}
@@ -0,0 +1,83 @@
+package bl3_auto_vip
+
+import (
+	"testing"
+)
+
+func TestVipActivityRuleMatches(t *testing.T) {
+	activity := VipActivity{Title: "Watch the Trailer", Link: "https://example.com/videos/trailer.mp4", Name: "bl3_trailer_1"}
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		{"title:*watch*", true},
+		{"title:WATCH*", true},
+		{"title:watch", false},
+		{"title:Watch the Trailer", true},
+		{"title:Watch the Traile?", true},
+		{"title:Watch the Trail?", false},
+		{"name:bl3_trailer_?", true},
+		{"name:bl3.trailer.1", false},
+		{"link:*(videos)*", false},
+		{"link:*/videos/*", true},
+		{"any:*trailer_1", true},
+		{"any:*twitter*", false},
+		{"title:re:^watch", true},
+		{"title:re:trailer$", true},
+		{"title:re:^trailer", false},
+		{"link:re:\\.mp4$", true},
+		{"name:re:bl3_trailer_\\d", true},
+		{"any:re:twitter|trailer", true},
+	}
+	for _, test := range tests {
+		rule, err := ParseVipActivityRule(RuleInclude, test.rule)
+		if err != nil {
+			t.Errorf("ParseVipActivityRule(%q) = %v", test.rule, err)
+			continue
+		}
+		if got := rule.Matches(activity); got != test.want {
+			t.Errorf("ParseVipActivityRule(%q).Matches() = %v, want %v", test.rule, got, test.want)
+		}
+	}
+}
+
+func TestParseVipActivityRuleInvalid(t *testing.T) {
+	for _, s := range []string{"*watch*", "date:*watch*", "title:re:(", "title:re:a{2,1}"} {
+		if _, err := ParseVipActivityRule(RuleExclude, s); err == nil {
+			t.Errorf("ParseVipActivityRule(%q) = nil, want an error", s)
+		}
+	}
+	if _, err := ParseVipActivityRule("skip", "title:*"); err == nil {
+		t.Errorf("ParseVipActivityRule(\"skip\", ...) = nil, want an error")
+	}
+}
+
+func TestClaimsActivity(t *testing.T) {
+	video := VipActivity{Title: "Watch the Trailer", Link: "https://example.com/video"}
+	tweet := VipActivity{Title: "Follow on Twitter", Link: "https://twitter.com/borderlands", Name: "twitter_follow"}
+	other := VipActivity{Title: "Visit the Store", Link: "https://example.com/store", Name: "store_visit"}
+	tests := []struct {
+		rules    []VipActivityRule
+		activity VipActivity
+		want     bool
+		wantRule string
+	}{
+		{nil, video, false, "exclude title:*watch*"},
+		{nil, tweet, true, ""},
+		{[]VipActivityRule{{Action: "Include", Field: "Name", Pattern: "twitter_*"}}, tweet, true, "include name:twitter_*"},
+		{[]VipActivityRule{{Action: "include", Field: "name", Pattern: "twitter_*"}}, other, false, "no include rule matched"},
+		{[]VipActivityRule{{Action: "include", Field: "any", Pattern: "re:twitter|store"}, {Action: "exclude", Field: "title", Pattern: "*store*"}}, other, false, "exclude title:*store*"},
+		{[]VipActivityRule{{Action: "include", Field: "any", Pattern: "re:twitter|store"}, {Action: "exclude", Field: "title", Pattern: "*store*"}}, tweet, true, "include any:re:twitter|store"},
+	}
+	for _, test := range tests {
+		conf := VipConfig{ActivityRules: test.rules}
+		if err := conf.CompileActivityRules(); err != nil {
+			t.Errorf("CompileActivityRules(%v) = %v", test.rules, err)
+			continue
+		}
+		got, rule := conf.ClaimsActivity(test.activity)
+		if got != test.want || rule != test.wantRule {
+			t.Errorf("ClaimsActivity(%q) with %v = %v, %q, want %v, %q", test.activity.Title, test.rules, got, rule, test.want, test.wantRule)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package bl3_auto_vip
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matt1484/bl3_auto_vip/crowdtwist"
+)
+
+// VipActivityReset is when frequency capped VIP activities can be claimed again.
+// Frequency is daily or weekly, Weekday only matters for weekly resets and Hour is in UTC.
+type VipActivityReset struct {
+	Frequency string `json:"frequency"`
+	Weekday   string `json:"weekday"`
+	Hour      int    `json:"hour"`
+	weekday   time.Weekday
+}
+
+// DefaultVipActivityReset is the weekly reset on Thursday, the same time as the game's
+var DefaultVipActivityReset = VipActivityReset{Frequency: "weekly", Weekday: "thursday", Hour: 16}
+
+func (reset *VipActivityReset) compile() error {
+	reset.Frequency = strings.ToLower(reset.Frequency)
+	if reset.Hour < 0 || reset.Hour > 23 {
+		return errors.New("invalid VIP activity reset hour " + strconv.Itoa(reset.Hour) + ", expected 0-23")
+	}
+	switch reset.Frequency {
+	case "daily":
+		return nil
+	case "weekly":
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if strings.EqualFold(day.String(), reset.Weekday) {
+				reset.weekday = day
+				return nil
+			}
+		}
+		return errors.New("invalid VIP activity reset weekday '" + reset.Weekday + "'")
+	}
+	return errors.New("invalid VIP activity reset frequency '" + reset.Frequency + "', expected daily or weekly")
+}
+
+// Next returns the first reset after now
+func (reset VipActivityReset) Next(now time.Time) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), reset.Hour, 0, 0, 0, time.UTC)
+	if reset.Frequency == "weekly" {
+		next = next.AddDate(0, 0, (int(reset.weekday)-int(next.Weekday())+7)%7)
+	}
+	for !next.After(now) {
+		if reset.Frequency == "weekly" {
+			next = next.AddDate(0, 0, 7)
+		} else {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return next
+}
+
+// CompileActivityReset checks the configured reset, using the default when there is none
+func (conf *VipConfig) CompileActivityReset() error {
+	if conf.ActivityReset.Frequency == "" {
+		conf.ActivityReset = DefaultVipActivityReset
+	}
+	return conf.ActivityReset.compile()
+}
+
+// nextAvailable returns when a capped activity can be claimed again: the date crowdtwist gives,
+// else the next reset of the activity's cap interval, else the next configured reset
+func (conf *VipConfig) nextAvailable(status crowdtwist.ActivityStatus, now time.Time) time.Time {
+	if next := parseCrowdtwistTime(status.NextAvailableDate); next.After(now) {
+		return next
+	}
+	reset := conf.ActivityReset
+	switch strings.ToLower(status.FreqCapInterval) {
+	case "day", "daily":
+		reset.Frequency = "daily"
+	case "week", "weekly":
+		reset.Frequency = "weekly"
+		if reset.Weekday == "" {
+			reset.Weekday = DefaultVipActivityReset.Weekday
+		}
+	}
+	if reset.compile() != nil {
+		reset = conf.ActivityReset
+	}
+	return reset.Next(now)
+}
+
+// NextVipActivityTime returns the earliest time one of the activities can be claimed,
+// which is now when one is not capped and zero when there are no activities
+func NextVipActivityTime(activities []VipActivity, now time.Time) time.Time {
+	next := time.Time{}
+	for _, activity := range activities {
+		if !activity.Capped {
+			return now
+		}
+		if next.IsZero() || activity.NextAvailable.Before(next) {
+			next = activity.NextAvailable
+		}
+	}
+	return next
+}
@@ -0,0 +1,92 @@
+package bl3_auto_vip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matt1484/bl3_auto_vip/crowdtwist"
+)
+
+func TestVipActivityResetNext(t *testing.T) {
+	weekly := VipActivityReset{Frequency: "weekly", Weekday: "Thursday", Hour: 16}
+	daily := VipActivityReset{Frequency: "Daily", Hour: 9}
+	for _, reset := range []*VipActivityReset{&weekly, &daily} {
+		if err := reset.compile(); err != nil {
+			t.Fatalf("compile(%+v) = %v", *reset, err)
+		}
+	}
+
+	// 2019-10-03 is a Thursday
+	tests := []struct {
+		reset VipActivityReset
+		now   time.Time
+		want  time.Time
+	}{
+		{weekly, time.Date(2019, 10, 3, 15, 59, 0, 0, time.UTC), time.Date(2019, 10, 3, 16, 0, 0, 0, time.UTC)},
+		{weekly, time.Date(2019, 10, 3, 16, 0, 0, 0, time.UTC), time.Date(2019, 10, 10, 16, 0, 0, 0, time.UTC)},
+		{weekly, time.Date(2019, 10, 1, 20, 0, 0, 0, time.UTC), time.Date(2019, 10, 3, 16, 0, 0, 0, time.UTC)},
+		{weekly, time.Date(2019, 10, 5, 1, 0, 0, 0, time.UTC), time.Date(2019, 10, 10, 16, 0, 0, 0, time.UTC)},
+		{weekly, time.Date(2019, 12, 27, 0, 0, 0, 0, time.UTC), time.Date(2020, 1, 2, 16, 0, 0, 0, time.UTC)},
+		{weekly, time.Date(2019, 10, 3, 11, 0, 0, 0, time.FixedZone("PDT", -7*3600)), time.Date(2019, 10, 10, 16, 0, 0, 0, time.UTC)},
+		{daily, time.Date(2019, 10, 3, 8, 0, 0, 0, time.UTC), time.Date(2019, 10, 3, 9, 0, 0, 0, time.UTC)},
+		{daily, time.Date(2019, 10, 3, 9, 0, 0, 0, time.UTC), time.Date(2019, 10, 4, 9, 0, 0, 0, time.UTC)},
+		{daily, time.Date(2019, 10, 31, 23, 0, 0, 0, time.UTC), time.Date(2019, 11, 1, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		if got := test.reset.Next(test.now); !got.Equal(test.want) {
+			t.Errorf("%s.Next(%v) = %v, want %v", test.reset.Frequency, test.now, got, test.want)
+		}
+	}
+}
+
+func TestVipActivityResetCompile(t *testing.T) {
+	tests := []struct {
+		reset   VipActivityReset
+		wantErr bool
+	}{
+		{VipActivityReset{Frequency: "weekly", Weekday: "monday", Hour: 0}, false},
+		{VipActivityReset{Frequency: "daily", Hour: 23}, false},
+		{VipActivityReset{Frequency: "weekly", Weekday: "someday", Hour: 16}, true},
+		{VipActivityReset{Frequency: "daily", Hour: 24}, true},
+		{VipActivityReset{Frequency: "monthly", Hour: 16}, true},
+	}
+	for _, test := range tests {
+		reset := test.reset
+		if err := reset.compile(); (err != nil) != test.wantErr {
+			t.Errorf("compile(%+v) = %v, want error %v", test.reset, err, test.wantErr)
+		}
+	}
+}
+
+func TestVipActivityNextAvailable(t *testing.T) {
+	conf := VipConfig{}
+	if err := conf.CompileActivityReset(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2019, 10, 5, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		status crowdtwist.ActivityStatus
+		want   time.Time
+	}{
+		{crowdtwist.ActivityStatus{NextAvailableDate: "2019-10-06 08:30:00"}, time.Date(2019, 10, 6, 8, 30, 0, 0, time.UTC)},
+		{crowdtwist.ActivityStatus{NextAvailableDate: "2019-10-01 08:30:00", FreqCapInterval: "day"}, time.Date(2019, 10, 5, 16, 0, 0, 0, time.UTC)},
+		{crowdtwist.ActivityStatus{FreqCapInterval: "daily"}, time.Date(2019, 10, 5, 16, 0, 0, 0, time.UTC)},
+		{crowdtwist.ActivityStatus{FreqCapInterval: "Week"}, time.Date(2019, 10, 10, 16, 0, 0, 0, time.UTC)},
+		{crowdtwist.ActivityStatus{FreqCapInterval: "lifetime"}, time.Date(2019, 10, 10, 16, 0, 0, 0, time.UTC)},
+		{crowdtwist.ActivityStatus{}, time.Date(2019, 10, 10, 16, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		if got := conf.nextAvailable(test.status, now); !got.Equal(test.want) {
+			t.Errorf("nextAvailable(%+v) = %v, want %v", test.status, got, test.want)
+		}
+	}
+
+	daily := VipConfig{ActivityReset: VipActivityReset{Frequency: "daily", Hour: 4}}
+	if err := daily.CompileActivityReset(); err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2019, 10, 10, 4, 0, 0, 0, time.UTC)
+	if got := daily.nextAvailable(crowdtwist.ActivityStatus{FreqCapInterval: "week"}, now); !got.Equal(want) {
+		t.Errorf("nextAvailable() of a weekly activity with a daily config = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+package bl3_auto_vip
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCrowdtwistTime(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Time
+	}{
+		{"2019-10-03 17:37:45", time.Date(2019, 10, 3, 17, 37, 45, 0, time.UTC)},
+		{"2019-10-03T17:37:45Z", time.Date(2019, 10, 3, 17, 37, 45, 0, time.UTC)},
+		{"2019-10-03T13:37:45-04:00", time.Date(2019, 10, 3, 17, 37, 45, 0, time.UTC)},
+		{"2019-10-03T17:37:45", time.Date(2019, 10, 3, 17, 37, 45, 0, time.UTC)},
+		{"1570124265", time.Date(2019, 10, 3, 17, 37, 45, 0, time.UTC)},
+		{" 2019-10-03 ", time.Date(2019, 10, 3, 0, 0, 0, 0, time.UTC)},
+		{"yesterday", time.Time{}},
+		{"", time.Time{}},
+	}
+	for _, test := range tests {
+		if got := parseCrowdtwistTime(test.s); !got.Equal(test.want) {
+			t.Errorf("parseCrowdtwistTime(%q) = %v, want %v", test.s, got, test.want)
+		}
+	}
+}
+
+func TestVipCodeTypeName(t *testing.T) {
+	tests := []struct {
+		widgetName string
+		want       string
+	}{
+		{"Creator Code Redemption", "creator"},
+		{"VIP Codes - Boss", "boss"},
+		{"code_redemption_email", "email"},
+		{"Code Redemption", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := vipCodeTypeName(test.widgetName); got != test.want {
+			t.Errorf("vipCodeTypeName(%q) = %q, want %q", test.widgetName, got, test.want)
+		}
+	}
+}
+
+func TestDiscoverVipCodeUrlsCached(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "vip-code-types.json")
+	cache := `{"updated": "` + time.Now().Format(time.RFC3339) + `", "codeTypeUrlMap": {
+		"vault": "https://example.com/vault", "diamond": "", "creator": "https://example.com/creator",
+		"boss": "https://example.com/boss", "dead": ""}}`
+	if err := ioutil.WriteFile(cachePath, []byte(cache), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := &Bl3Client{}
+	client.Config.Vip.CodeTypeUrlMap = map[string]string{
+		"vault":   "https://example.com/old-vault",
+		"diamond": "https://example.com/diamond",
+		"creator": "https://example.com/creator",
+	}
+
+	warnings, err := client.DiscoverVipCodeUrls(cachePath, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"vault":   "https://example.com/vault",
+		"diamond": "https://example.com/diamond",
+		"creator": "https://example.com/creator",
+		"boss":    "https://example.com/boss",
+	}
+	if !reflect.DeepEqual(client.Config.Vip.CodeTypeUrlMap, want) {
+		t.Errorf("DiscoverVipCodeUrls() urls = %v, want %v", client.Config.Vip.CodeTypeUrlMap, want)
+	}
+	wantWarnings := []string{
+		"could not discover the url for 'diamond' VIP codes, using https://example.com/diamond",
+		"found 'boss' VIP codes that are not in the config, using https://example.com/boss",
+		"the 'vault' VIP code url changed from https://example.com/old-vault to https://example.com/vault",
+	}
+	if !reflect.DeepEqual(warnings, wantWarnings) {
+		t.Errorf("DiscoverVipCodeUrls() warnings = %q, want %q", warnings, wantWarnings)
+	}
+}